@@ -6,28 +6,35 @@ import (
 	"time"
 
 	"github.com/gobwas/glob"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
 	"gitlab.com/lepovirta/konvahti/internal/envvars"
 	"gitlab.com/lepovirta/konvahti/internal/file"
 )
 
 type Config struct {
-	Name              string          `yaml:"name"`
-	MatchFiles        []string        `yaml:"matchFiles"`
-	EnvVars           envvars.EnvVars `yaml:"env"`
-	InheritAllEnvVars bool            `yaml:"inheritAllEnvVars"`
-	InheritEnvVars    []string        `yaml:"inheritEnvVars,omitempty"`
-	WorkDir           string          `yaml:"workDirectory,omitempty"`
-	PreCommand        []string        `yaml:"preCommand,omitempty"`
-	Command           []string        `yaml:"command"`
-	PostCommand       []string        `yaml:"postCommand,omitempty"`
-	Timeout           time.Duration   `yaml:"timeout,omitempty"`
-	MaxRetries        int             `yaml:"maxRetries"`
+	Name              string           `yaml:"name"`
+	MatchFiles        []string         `yaml:"matchFiles"`
+	On                []changeset.Kind `yaml:"on,omitempty"`
+	EnvVars           envvars.EnvVars  `yaml:"env"`
+	InheritAllEnvVars bool             `yaml:"inheritAllEnvVars"`
+	InheritEnvVars    []string         `yaml:"inheritEnvVars,omitempty"`
+	WorkDir           string           `yaml:"workDirectory,omitempty"`
+	PreCommand        []string         `yaml:"preCommand,omitempty"`
+	Command           []string         `yaml:"command"`
+	PostCommand       []string         `yaml:"postCommand,omitempty"`
+	Timeout           time.Duration    `yaml:"timeout,omitempty"`
+	MaxRetries        int              `yaml:"maxRetries"`
 }
 
 func (c *Config) Validate() error {
 	if len(c.Command) == 0 {
 		return fmt.Errorf("no action command specified for action %s", c.Name)
 	}
+	for _, kind := range c.On {
+		if err := kind.Validate(); err != nil {
+			return fmt.Errorf("invalid trigger for action %s: %w", c.Name, err)
+		}
+	}
 	return nil
 }
 
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gobwas/glob"
 	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
 	"gitlab.com/lepovirta/konvahti/internal/envvars"
 	"gitlab.com/lepovirta/konvahti/internal/exec"
 	"gitlab.com/lepovirta/konvahti/internal/retry"
@@ -76,9 +77,17 @@ func (r *Runner) MatchAny(filenames []string) string {
 	return ""
 }
 
+// MatchChanges finds a changed file that matches both the file patterns and the change kinds of the action.
+func (r *Runner) MatchChanges(changes changeset.ChangeSet) string {
+	return r.MatchAny(changes.OfKinds(r.config.On).Paths())
+}
+
+// Run runs the action commands. The source environment variables describe
+// the file source refresh that triggered the action.
 func (r *Runner) Run(
 	ctx context.Context,
 	logger zerolog.Logger,
+	sourceEnvVars envvars.EnvVars,
 ) (execErr error) {
 	logCtx := logger.With().Str("action", r.config.Name)
 	logger = logCtx.Logger()
@@ -96,7 +105,7 @@ func (r *Runner) Run(
 					preCommandCtx,
 					logCtx.Str("stage", "preCommand"),
 					r.config.PreCommand,
-					nil,
+					sourceEnvVars,
 				)
 			},
 		)
@@ -117,7 +126,7 @@ func (r *Runner) Run(
 					commandCtx,
 					logCtx.Str("stage", "command"),
 					r.config.Command,
-					nil,
+					sourceEnvVars,
 				)
 			},
 		)
@@ -139,7 +148,7 @@ func (r *Runner) Run(
 					postCommandCtx,
 					logCtx.Str("stage", "postCommand"),
 					r.config.PostCommand,
-					envvars.FromKeyValue(outcomeEnvKey, outcome),
+					sourceEnvVars.Join(envvars.FromKeyValue(outcomeEnvKey, outcome)),
 				)
 			},
 		)
@@ -10,6 +10,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
 	"gitlab.com/lepovirta/konvahti/internal/envvars"
 	"gitlab.com/lepovirta/konvahti/internal/exec"
 )
@@ -58,7 +59,7 @@ func TestExecuteSuccessful(t *testing.T) {
 		return
 	}
 
-	if err := runner.Run(ctx, log.Logger); !assert.NoError(t, err) {
+	if err := runner.Run(ctx, log.Logger, nil); !assert.NoError(t, err) {
 		return
 	}
 
@@ -83,6 +84,49 @@ func TestExecuteSuccessful(t *testing.T) {
 	}, executor.calls)
 }
 
+func TestExecuteWithSourceEnvVars(t *testing.T) {
+	ctx := context.Background()
+	executor := newFakeExecutor()
+	executor.responses["reload.py"] = response{0, nil}
+	executor.responses["report.sh"] = response{0, nil}
+	var runner Runner
+	err := runner.Setup(
+		executor,
+		oneMsBackoff,
+		testDefaultWorkDir,
+		testBgEnvVars,
+		Config{
+			Name:        "sourceenv",
+			Command:     []string{"reload.py", "2"},
+			PostCommand: []string{"report.sh", "3"},
+			EnvVars:     testEnvVars,
+		},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sourceEnvVars := envvars.FromKeyValue("KONVAHTI_OCI_DIGEST", "sha256:1234")
+	if err := runner.Run(ctx, log.Logger, sourceEnvVars); !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []exec.Command{
+		{
+			Args:    []string{"reload.py", "2"},
+			WorkDir: testDefaultWorkDir,
+			Env:     testEnvVars.Join(sourceEnvVars),
+		},
+		{
+			Args:    []string{"report.sh", "3"},
+			WorkDir: testDefaultWorkDir,
+			Env: testEnvVars.Join(sourceEnvVars).Join(
+				envvars.FromKeyValue("KONVAHTI_ACTION_STATUS", "success"),
+			),
+		},
+	}, executor.calls)
+}
+
 func TestExecuteFailingPreCommand(t *testing.T) {
 	ctx := context.Background()
 	executor := newFakeExecutor()
@@ -110,7 +154,7 @@ func TestExecuteFailingPreCommand(t *testing.T) {
 		return
 	}
 
-	err = runner.Run(ctx, log.Logger)
+	err = runner.Run(ctx, log.Logger, nil)
 	assert.Error(t, err)
 	assert.Equal(t, errSimulatedExitError, err)
 	assert.Equal(t, []exec.Command{
@@ -173,7 +217,7 @@ func TestExecuteFailingCommand(t *testing.T) {
 		return
 	}
 
-	err = runner.Run(ctx, log.Logger)
+	err = runner.Run(ctx, log.Logger, nil)
 	assert.Error(t, err)
 	assert.Equal(t, errSimulatedExitError, err)
 	assert.Equal(t, []exec.Command{
@@ -230,7 +274,7 @@ func TestExecuteFailingPostCommand(t *testing.T) {
 		return
 	}
 
-	err = runner.Run(ctx, log.Logger)
+	err = runner.Run(ctx, log.Logger, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, []exec.Command{
 		{
@@ -295,3 +339,38 @@ func (f *fakeExecutor) Run(
 	}
 	return -1, osexec.ErrNotFound
 }
+
+func TestMatchChanges(t *testing.T) {
+	a := assert.New(t)
+	var runner Runner
+	err := runner.Setup(
+		newFakeExecutor(),
+		oneMsBackoff,
+		testDefaultWorkDir,
+		testBgEnvVars,
+		Config{
+			Name:       "cleanup",
+			MatchFiles: []string{"apps/*.yaml"},
+			On:         []changeset.Kind{changeset.Deleted, changeset.Renamed},
+			Command:    []string{"cleanup.sh"},
+		},
+	)
+	if !a.NoError(err) {
+		return
+	}
+
+	a.Empty(runner.MatchChanges(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "apps/web.yaml"},
+		{Kind: changeset.Deleted, Path: "docs/web.md"},
+	}))
+	a.Equal("apps/db.yaml", runner.MatchChanges(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "apps/web.yaml"},
+		{Kind: changeset.Deleted, Path: "apps/db.yaml"},
+	}))
+	a.Equal("apps/backend.yaml", runner.MatchChanges(changeset.ChangeSet{
+		{Kind: changeset.Renamed, Path: "apps/api.yaml", OldPath: "apps/backend.yaml"},
+	}))
+
+	config := Config{Name: "cleanup", Command: []string{"cleanup.sh"}, On: []changeset.Kind{"removed"}}
+	a.Error(config.Validate())
+}
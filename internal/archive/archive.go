@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+const (
+	downloadFilePrefix = ".download-"
+)
+
+var (
+	errNoChanges = errors.New("no changes found")
+)
+
+type ArchiveSource struct {
+	fs              billy.Filesystem
+	config          Config
+	format          string
+	fetcher         fetcher
+	etag            string
+	hashes          stat.Versions
+	latestDirectory string
+	changes         changeset.ChangeSet
+}
+
+func (s *ArchiveSource) Setup(fs billy.Filesystem, config Config) (err error) {
+	if s.format, err = config.format(); err != nil {
+		return
+	}
+	if config.S3 != nil {
+		s.fetcher, err = newS3Fetcher(config.S3)
+	} else {
+		s.fetcher, err = newURLFetcher(&config)
+	}
+	if err != nil {
+		return
+	}
+	s.fs = fs
+	s.config = config
+	s.etag = ""
+	s.hashes = nil
+	s.changes = nil
+	s.latestDirectory = fs.Join(config.Directory, file.LatestLinkName)
+	return nil
+}
+
+func (s *ArchiveSource) GetDirectory() string {
+	return s.latestDirectory
+}
+
+func (s *ArchiveSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("refreshing files from archive")
+
+	// The hashes of the previous snapshot are only calculated from the file system
+	// when they are not available in memory (e.g. after a reboot).
+	if s.hashes == nil {
+		hashes, err := file.HashDirectory(s.fs, s.latestDirectory)
+		if err != nil {
+			return nil, err
+		}
+		s.hashes = hashes
+	}
+
+	if err := s.fs.MkdirAll(s.config.Directory, 0750); err != nil {
+		return nil, err
+	}
+	archiveFile, err := util.TempFile(s.fs, s.config.Directory, downloadFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer s.removeDownload(archiveFile, logger)
+
+	logger.Debug().Msg("downloading archive")
+	etag, unchanged, err := s.fetcher.fetch(ctx, archiveFile, s.etag)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		logger.Debug().Msg("archive not modified")
+		return nil, nil
+	}
+	size, err := archiveFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := archiveFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var changes changeset.ChangeSet
+	var nextHashes stat.Versions
+	nextDirectory := s.fs.Join(s.config.Directory, file.SnapshotName())
+	err = file.SwapDirectory(
+		s.fs,
+		s.latestDirectory,
+		nextDirectory,
+		func(fs billy.Filesystem) error {
+			logger.Debug().Str("format", s.format).Msg("unpacking archive")
+			hashes, err := unpack(fs, s.format, archiveFile, size)
+			if err != nil {
+				return err
+			}
+			changes = s.hashes.Changes(hashes)
+			nextHashes = hashes
+
+			// Keep the current directory in place when the contents haven't changed
+			if len(changes) == 0 {
+				return errNoChanges
+			}
+			return nil
+		},
+	)
+	if errors.Is(err, errNoChanges) {
+		logger.Debug().Msg("no changes found")
+		s.etag = etag
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.etag = etag
+	s.hashes = nextHashes
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *ArchiveSource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *ArchiveSource) removeDownload(f billy.File, logger zerolog.Logger) {
+	if err := f.Close(); err != nil {
+		logger.Error().Err(err).Msg("failed to close downloaded archive")
+	}
+	if err := s.fs.Remove(f.Name()); err != nil {
+		logger.Error().Err(err).Msg("failed to delete downloaded archive")
+	}
+}
+
+func (s *ArchiveSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	logCtx := logger.With().
+		Str("stage", "refresh").
+		Str("archiveFormat", s.format)
+	if s.config.S3 != nil {
+		logCtx = logCtx.
+			Str("s3Endpoint", s.config.S3.Endpoint).
+			Str("s3BucketName", s.config.S3.BucketName).
+			Str("s3ObjectKey", s.config.S3.ObjectKey)
+	} else {
+		logCtx = logCtx.Str("archiveUrl", s.config.URL)
+	}
+	return logCtx.Logger()
+}
@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	version := 1
+	archives := map[int][]byte{
+		1: tarGzArchive(t, map[string]string{
+			"app.yaml": "version: 1",
+			"db.yaml":  "host: localhost",
+			"old.yaml": "deprecated: true",
+		}),
+		2: tarGzArchive(t, map[string]string{
+			"app.yaml": "version: 1",
+			"db.yaml":  "host: db.example.org",
+		}),
+		3: tarGzArchive(t, map[string]string{
+			"db.yaml":  "host: db.example.org",
+			"app.yaml": "version: 1",
+		}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(archives[version])
+	}))
+	defer server.Close()
+
+	var source ArchiveSource
+	if err := source.Setup(fs, Config{
+		URL:       server.URL + "/bundle.tar.gz",
+		Directory: "archive",
+	}); !a.NoError(err) {
+		return
+	}
+
+	// First refresh lists all files as changed
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml", "old.yaml"}, changed)
+
+	// Archive is not modified
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	// Only changed and removed files are listed
+	version = 2
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"db.yaml", "old.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "db.yaml"},
+		{Kind: changeset.Deleted, Path: "old.yaml"},
+	}, source.Changes())
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "db.yaml"))
+	if a.NoError(err) {
+		a.Equal("host: db.example.org", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "old.yaml"))
+	a.Error(err)
+
+	// New archive with the same contents
+	version = 3
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	// Previous hashes are calculated from the file system after a restart
+	var restartedSource ArchiveSource
+	if err := restartedSource.Setup(fs, Config{
+		URL:       server.URL + "/bundle.tar.gz",
+		Directory: "archive",
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err = restartedSource.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+}
+
+func TestFormatFromName(t *testing.T) {
+	a := assert.New(t)
+
+	for name, expected := range map[string]string{
+		"bundle.tar.gz":        FormatTarGz,
+		"configs/bundle.TGZ":   FormatTarGz,
+		"bundle-1.2.3.tar":     FormatTar,
+		"/releases/bundle.zip": FormatZip,
+	} {
+		format, err := formatFromName(name)
+		if a.NoError(err) {
+			a.Equal(expected, format)
+		}
+	}
+
+	_, err := formatFromName("bundle.rar")
+	a.Error(err)
+}
@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+	"gitlab.com/lepovirta/konvahti/internal/s3"
+)
+
+const (
+	FormatTarGz = "tar.gz"
+	FormatTar   = "tar"
+	FormatZip   = "zip"
+)
+
+type Config struct {
+	URL       string                `yaml:"url,omitempty"`
+	Auth      httpclient.AuthConfig `yaml:"auth,omitempty"`
+	TLS       httpclient.TLSConfig  `yaml:"tls,omitempty"`
+	S3        *s3.ObjectConfig      `yaml:"s3,omitempty"`
+	Format    string                `yaml:"format,omitempty"`
+	Directory string                `yaml:"directory"`
+}
+
+func (c *Config) Validate() error {
+	if c.URL == "" && c.S3 == nil {
+		return fmt.Errorf("no archive URL or S3 object specified")
+	}
+	if c.URL != "" && c.S3 != nil {
+		return fmt.Errorf("both archive URL and S3 object specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if c.URL != "" {
+		if err := c.Auth.Validate(); err != nil {
+			return fmt.Errorf("invalid HTTP auth: %w", err)
+		}
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("invalid TLS config: %w", err)
+		}
+	}
+	if c.S3 != nil {
+		if err := c.S3.Validate(); err != nil {
+			return fmt.Errorf("invalid S3 object: %w", err)
+		}
+	}
+	if _, err := c.format(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) format() (string, error) {
+	if c.Format != "" {
+		return normalizeFormat(c.Format)
+	}
+
+	name := c.URL
+	if c.S3 != nil {
+		name = c.S3.ObjectKey
+	} else if u, err := url.Parse(c.URL); err == nil {
+		name = u.Path
+	}
+	return formatFromName(name)
+}
+
+func normalizeFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "tar":
+		return FormatTar, nil
+	case "zip":
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %s", format)
+	}
+}
+
+func formatFromName(name string) (string, error) {
+	name = strings.ToLower(name)
+	for _, suffix := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, suffix) {
+			return normalizeFormat(strings.TrimPrefix(suffix, "."))
+		}
+	}
+	return "", fmt.Errorf("unable to detect archive format from %s", name)
+}
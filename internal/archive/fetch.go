@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+	"gitlab.com/lepovirta/konvahti/internal/s3"
+)
+
+// fetcher downloads the archive unless it matches the given ETag.
+// The returned ETag is stored by the caller and passed to the next fetch.
+type fetcher interface {
+	fetch(ctx context.Context, w io.Writer, prevETag string) (etag string, unchanged bool, err error)
+}
+
+type urlFetcher struct {
+	client *http.Client
+	url    string
+	auth   httpclient.AuthConfig
+}
+
+func newURLFetcher(config *Config) (*urlFetcher, error) {
+	client, err := httpclient.New(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &urlFetcher{
+		client: client,
+		url:    config.URL,
+		auth:   config.Auth,
+	}, nil
+}
+
+func (f *urlFetcher) fetch(
+	ctx context.Context,
+	w io.Writer,
+	prevETag string,
+) (etag string, unchanged bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return
+	}
+	f.auth.Apply(req)
+	if prevETag != "" {
+		req.Header.Set("If-None-Match", prevETag)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := res.Body.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return prevETag, true, nil
+	case http.StatusOK:
+		etag = res.Header.Get("ETag")
+		_, err = io.Copy(w, res.Body)
+		return
+	default:
+		err = fmt.Errorf("unexpected HTTP status %s from %s", res.Status, f.url)
+		return
+	}
+}
+
+type s3Fetcher struct {
+	client     *minio.Client
+	bucketName string
+	objectKey  string
+}
+
+func newS3Fetcher(config *s3.ObjectConfig) (*s3Fetcher, error) {
+	client, err := config.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return &s3Fetcher{
+		client:     client,
+		bucketName: config.BucketName,
+		objectKey:  config.ObjectKey,
+	}, nil
+}
+
+func (f *s3Fetcher) fetch(
+	ctx context.Context,
+	w io.Writer,
+	prevETag string,
+) (etag string, unchanged bool, err error) {
+	info, err := f.client.StatObject(ctx, f.bucketName, f.objectKey, minio.StatObjectOptions{})
+	if err != nil {
+		return
+	}
+	if prevETag != "" && info.ETag == prevETag {
+		return prevETag, true, nil
+	}
+
+	// The ETag is matched during the download, so that the content
+	// doesn't change between the stat and the download.
+	opts := minio.GetObjectOptions{}
+	if err = opts.SetMatchETag(info.ETag); err != nil {
+		return
+	}
+	object, err := f.client.GetObject(ctx, f.bucketName, f.objectKey, opts)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := object.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = io.Copy(w, object)
+	return info.ETag, false, err
+}
@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+func unpack(fs billy.Filesystem, format string, archiveFile billy.File, size int64) (stat.Versions, error) {
+	switch format {
+	case FormatTarGz:
+		return UnpackTarGz(fs, archiveFile)
+	case FormatTar:
+		return UnpackTar(fs, archiveFile)
+	case FormatZip:
+		return UnpackZip(fs, archiveFile, size)
+	default:
+		return nil, fmt.Errorf("unsupported archive format %s", format)
+	}
+}
+
+// UnpackTarGz extracts the regular files from a gzipped tarball to the given file system.
+// The SHA-256 hashes of the extracted files are returned.
+func UnpackTarGz(fs billy.Filesystem, r io.Reader) (stat.Versions, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	return UnpackTar(fs, gzr)
+}
+
+// UnpackTar extracts the regular files from a tarball to the given file system.
+// The SHA-256 hashes of the extracted files are returned.
+func UnpackTar(fs billy.Filesystem, r io.Reader) (stat.Versions, error) {
+	hashes := make(stat.Versions)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := writeEntry(fs, header.Name, header.FileInfo().Mode(), tr, hashes); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// UnpackZip extracts the regular files from a zip archive to the given file system.
+// The SHA-256 hashes of the extracted files are returned.
+func UnpackZip(fs billy.Filesystem, r io.ReaderAt, size int64) (stat.Versions, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(stat.Versions)
+	for _, entry := range zr.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		if err := writeZipEntry(fs, entry, hashes); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func writeZipEntry(fs billy.Filesystem, entry *zip.File, hashes stat.Versions) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return writeEntry(fs, entry.Name, entry.Mode(), rc, hashes)
+}
+
+// WriteEntry writes a single file to the given file system, and adds its SHA-256 hash to the hashes.
+func WriteEntry(fs billy.Filesystem, name string, r io.Reader, hashes stat.Versions) error {
+	return writeEntry(fs, name, 0644, r, hashes)
+}
+
+func writeEntry(
+	fs billy.Filesystem,
+	name string,
+	mode os.FileMode,
+	r io.Reader,
+	hashes stat.Versions,
+) (err error) {
+	filename, err := SanitizeEntryName(name)
+	if err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(path.Dir(filename), 0750); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err = io.Copy(f, io.TeeReader(r, hash)); err != nil {
+		return
+	}
+	hashes[filename] = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// SanitizeEntryName prevents archive entries from being written outside the target directory.
+func SanitizeEntryName(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimLeft(strings.ReplaceAll(name, "\\", "/"), "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid archive entry name %s", name)
+	}
+	return cleaned, nil
+}
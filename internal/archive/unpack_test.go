@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var (
+	testArchiveFiles = map[string]string{
+		"app.yaml":          "version: 1",
+		"./configs/db.yaml": "host: localhost",
+	}
+)
+
+func assertUnpacked(a *assert.Assertions, fs billy.Filesystem, hashes map[string]string) {
+	a.Len(hashes, 2)
+	for filename, content := range map[string]string{
+		"app.yaml":        "version: 1",
+		"configs/db.yaml": "host: localhost",
+	} {
+		a.Contains(hashes, filename)
+		data, err := util.ReadFile(fs, filename)
+		if a.NoError(err) {
+			a.Equal(content, string(data))
+		}
+	}
+}
+
+func TestUnpackTarGz(t *testing.T) {
+	a := assert.New(t)
+	fs := memfs.New()
+
+	hashes, err := UnpackTarGz(fs, bytes.NewReader(tarGzArchive(t, testArchiveFiles)))
+	if a.NoError(err) {
+		assertUnpacked(a, fs, hashes)
+	}
+}
+
+func TestUnpackZip(t *testing.T) {
+	a := assert.New(t)
+	fs := memfs.New()
+	data := zipArchive(t, testArchiveFiles)
+
+	hashes, err := UnpackZip(fs, bytes.NewReader(data), int64(len(data)))
+	if a.NoError(err) {
+		assertUnpacked(a, fs, hashes)
+	}
+}
+
+func TestUnpackRejectsParentPaths(t *testing.T) {
+	fs := memfs.New()
+	_, err := UnpackTarGz(fs, bytes.NewReader(tarGzArchive(t, map[string]string{
+		"../../etc/passwd": "root",
+	})))
+	assert.Error(t, err)
+}
+
+func TestSanitizeEntryName(t *testing.T) {
+	a := assert.New(t)
+
+	for name, expected := range map[string]string{
+		"app.yaml":            "app.yaml",
+		"./app.yaml":          "app.yaml",
+		"/configs/app.yaml":   "configs/app.yaml",
+		"configs/../app.yaml": "app.yaml",
+	} {
+		sanitized, err := SanitizeEntryName(name)
+		if a.NoError(err) {
+			a.Equal(expected, sanitized)
+		}
+	}
+
+	for _, name := range []string{"..", "../app.yaml", "configs/../../app.yaml", "."} {
+		_, err := SanitizeEntryName(name)
+		a.Error(err, name)
+	}
+}
@@ -0,0 +1,215 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	storageScope          = "https://storage.azure.com/.default"
+	storageResource       = "https://storage.azure.com/"
+	managedIdentityURL    = "http://169.254.169.254/metadata/identity/oauth2/token"
+	managedIdentityAPIVer = "2018-02-01"
+)
+
+// authorizer adds the authentication details to a request
+type authorizer func(req *http.Request) error
+
+func newAuthorizer(config Config) (authorizer, error) {
+	switch {
+	case config.AccountKey != "":
+		key, err := base64.StdEncoding.DecodeString(config.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure account key: %w", err)
+		}
+		return sharedKeyAuthorizer(config.AccountName, key), nil
+	case config.SASToken != "":
+		return sasAuthorizer(config.SASToken)
+	case config.ServicePrincipal.isSet():
+		ccConfig := clientcredentials.Config{
+			ClientID:     config.ServicePrincipal.ClientID,
+			ClientSecret: config.ServicePrincipal.ClientSecret,
+			TokenURL: fmt.Sprintf(
+				"https://login.microsoftonline.com/%s/oauth2/v2.0/token",
+				url.PathEscape(config.ServicePrincipal.TenantID),
+			),
+			Scopes: []string{storageScope},
+		}
+		return tokenAuthorizer(ccConfig.TokenSource(context.Background())), nil
+	case config.UseManagedIdentity:
+		return tokenAuthorizer(oauth2.ReuseTokenSource(nil, &managedIdentityTokenSource{})), nil
+	default:
+		// Anonymous access to public containers
+		return func(req *http.Request) error { return nil }, nil
+	}
+}
+
+func sasAuthorizer(sasToken string) (authorizer, error) {
+	sasQuery, err := url.ParseQuery(strings.TrimPrefix(sasToken, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure SAS token: %w", err)
+	}
+	return func(req *http.Request) error {
+		query := req.URL.Query()
+		for key, values := range sasQuery {
+			for _, value := range values {
+				query.Add(key, value)
+			}
+		}
+		req.URL.RawQuery = query.Encode()
+		return nil
+	}, nil
+}
+
+func tokenAuthorizer(tokenSource oauth2.TokenSource) authorizer {
+	return func(req *http.Request) error {
+		token, err := tokenSource.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get Azure access token: %w", err)
+		}
+		token.SetAuthHeader(req)
+		return nil
+	}
+}
+
+func sharedKeyAuthorizer(accountName string, key []byte) authorizer {
+	return func(req *http.Request) error {
+		req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+		mac := hmac.New(sha256.New, key)
+		if _, err := mac.Write([]byte(sharedKeyStringToSign(accountName, req))); err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf(
+			"SharedKey %s:%s",
+			accountName, base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		))
+		return nil
+	}
+}
+
+// sharedKeyStringToSign builds the string to sign for the Shared Key authorization.
+// See: https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func sharedKeyStringToSign(accountName string, req *http.Request) string {
+	contentLength := req.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date is always provided in x-ms-date instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+	return strings.Join(lines, "\n") + "\n" +
+		canonicalizedHeaders(req.Header) +
+		canonicalizedResource(accountName, req.URL)
+}
+
+func canonicalizedHeaders(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-ms-") {
+			names = append(names, lowerName)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(strings.TrimSpace(header.Get(name)))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func canonicalizedResource(accountName string, u *url.URL) string {
+	var sb strings.Builder
+	sb.WriteString("/")
+	sb.WriteString(accountName)
+	sb.WriteString(u.EscapedPath())
+
+	query := make(map[string][]string)
+	for name, values := range u.Query() {
+		lowerName := strings.ToLower(name)
+		query[lowerName] = append(query[lowerName], values...)
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		sb.WriteString("\n")
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(strings.Join(values, ","))
+	}
+	return sb.String()
+}
+
+// managedIdentityTokenSource fetches tokens from the Azure instance metadata service
+type managedIdentityTokenSource struct{}
+
+func (ts *managedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{}
+	query.Set("api-version", managedIdentityAPIVer)
+	query.Set("resource", storageResource)
+	req, err := http.NewRequest(http.MethodGet, managedIdentityURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s from managed identity endpoint", res.Status)
+	}
+
+	var tokenRes struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return nil, err
+	}
+	expiresIn, err := tokenRes.ExpiresIn.Int64()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: tokenRes.AccessToken,
+		TokenType:   tokenRes.TokenType,
+		Expiry:      time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,140 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/blob"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+const (
+	apiVersion = "2020-10-02"
+)
+
+type AzureBlobSource struct {
+	blob.Source
+}
+
+func (s *AzureBlobSource) Setup(fs billy.Filesystem, config Config) error {
+	authorize, err := newAuthorizer(config)
+	if err != nil {
+		return err
+	}
+	s.Source.Setup(fs, &store{
+		client:    &http.Client{},
+		authorize: authorize,
+		endpoint:  config.endpoint(),
+		container: config.ContainerName,
+		prefix:    blob.SanitizePrefix(config.BlobPrefix),
+	}, config.Directory)
+	return nil
+}
+
+type store struct {
+	client    *http.Client
+	authorize authorizer
+	endpoint  string
+	container string
+	prefix    string
+}
+
+type listResponse struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				Etag string `xml:"Etag"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (s *store) List(ctx context.Context) (stat.Versions, error) {
+	versions := make(stat.Versions, 100)
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		listURL := fmt.Sprintf("%s/%s?%s", s.endpoint, url.PathEscape(s.container), query.Encode())
+
+		res, err := s.get(ctx, listURL)
+		if err != nil {
+			return nil, err
+		}
+		var listRes listResponse
+		err = xml.NewDecoder(res.Body).Decode(&listRes)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range listRes.Blobs.Blob {
+			if filename, ok := blob.KeyToFilename(s.prefix, b.Name); ok {
+				versions[filename] = b.Properties.Etag
+			}
+		}
+
+		if listRes.NextMarker == "" {
+			return versions, nil
+		}
+		marker = listRes.NextMarker
+	}
+}
+
+func (s *store) Download(ctx context.Context, filename string, w io.Writer) error {
+	downloadURL := fmt.Sprintf(
+		"%s/%s/%s",
+		s.endpoint, url.PathEscape(s.container), (&url.URL{Path: s.prefix + filename}).EscapedPath(),
+	)
+	res, err := s.get(ctx, downloadURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (s *store) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	if err := s.authorize(req); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s from Azure Blob Storage", res.Status)
+	}
+	return res, nil
+}
+
+func (s *store) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx.
+		Str("azureEndpoint", s.endpoint).
+		Str("azureContainerName", s.container).
+		Str("azureBlobPrefix", s.prefix)
+}
@@ -0,0 +1,139 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+type testBlob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		Etag string `xml:"Etag"`
+	} `xml:"Properties"`
+}
+
+func fakeAzureBlob(t *testing.T, container string, etags, contents map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "secret" || r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/"+container && r.URL.Query().Get("comp") == "list" {
+			res := struct {
+				XMLName xml.Name   `xml:"EnumerationResults"`
+				Blobs   []testBlob `xml:"Blobs>Blob"`
+			}{}
+			for name, etag := range etags {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					b := testBlob{Name: name}
+					b.Properties.Etag = etag
+					res.Blobs = append(res.Blobs, b)
+				}
+			}
+			if err := xml.NewEncoder(w).Encode(res); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		content, ok := contents[strings.TrimPrefix(r.URL.Path, "/"+container+"/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	etags := map[string]string{
+		"configs/app.yaml":    "0x1",
+		"configs/sub/db.yaml": "0x1",
+	}
+	contents := map[string]string{
+		"configs/app.yaml":    "version: 1",
+		"configs/sub/db.yaml": "host: localhost",
+	}
+	server := httptest.NewServer(fakeAzureBlob(t, "kontti", etags, contents))
+	defer server.Close()
+
+	var source AzureBlobSource
+	if err := source.Setup(fs, Config{
+		AccountName:   "devstoreaccount1",
+		Endpoint:      server.URL,
+		ContainerName: "kontti",
+		BlobPrefix:    "configs",
+		Directory:     "azure",
+		SASToken:      "?sv=2020-10-02&sig=secret",
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "sub/db.yaml"}, changed)
+
+	delete(etags, "configs/sub/db.yaml")
+	etags["configs/app.yaml"] = "0x2"
+	contents["configs/app.yaml"] = "version: 2"
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "sub/db.yaml"}, changed)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+}
+
+func TestSharedKeyStringToSign(t *testing.T) {
+	u, err := url.Parse("http://127.0.0.1:10000/devstoreaccount1/kontti?restype=container&comp=list&prefix=configs%2F")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Header: http.Header{},
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", "Mon, 14 Feb 2022 12:00:00 GMT")
+
+	assert.Equal(
+		t,
+		"GET\n\n\n\n\n\n\n\n\n\n\n\n"+
+			"x-ms-date:Mon, 14 Feb 2022 12:00:00 GMT\n"+
+			"x-ms-version:2020-10-02\n"+
+			"/devstoreaccount1/devstoreaccount1/kontti\n"+
+			"comp:list\n"+
+			"prefix:configs/\n"+
+			"restype:container",
+		sharedKeyStringToSign("devstoreaccount1", req),
+	)
+}
@@ -0,0 +1,68 @@
+package azureblob
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Config struct {
+	AccountName        string               `yaml:"accountName"`
+	Endpoint           string               `yaml:"endpoint,omitempty"`
+	ContainerName      string               `yaml:"containerName"`
+	BlobPrefix         string               `yaml:"blobPrefix"`
+	Directory          string               `yaml:"directory"`
+	AccountKey         string               `yaml:"accountKey,omitempty"`
+	SASToken           string               `yaml:"sasToken,omitempty"`
+	ServicePrincipal   ServicePrincipalAuth `yaml:"servicePrincipal,omitempty"`
+	UseManagedIdentity bool                 `yaml:"useManagedIdentity,omitempty"`
+}
+
+type ServicePrincipalAuth struct {
+	TenantID     string `yaml:"tenantId"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+}
+
+func (c *ServicePrincipalAuth) isSet() bool {
+	return c.TenantID != "" || c.ClientID != "" || c.ClientSecret != ""
+}
+
+func (c *Config) Validate() error {
+	if c.AccountName == "" {
+		return fmt.Errorf("no Azure storage account name specified")
+	}
+	if c.ContainerName == "" {
+		return fmt.Errorf("no Azure blob container name specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+
+	authCount := 0
+	for _, isSet := range []bool{
+		c.AccountKey != "",
+		c.SASToken != "",
+		c.ServicePrincipal.isSet(),
+		c.UseManagedIdentity,
+	} {
+		if isSet {
+			authCount++
+		}
+	}
+	if authCount > 1 {
+		return fmt.Errorf("too many Azure authentication methods specified")
+	}
+	if c.ServicePrincipal.isSet() {
+		if c.ServicePrincipal.TenantID == "" || c.ServicePrincipal.ClientID == "" || c.ServicePrincipal.ClientSecret == "" {
+			return fmt.Errorf("service principal requires tenant ID, client ID, and client secret")
+		}
+	}
+	return nil
+}
+
+func (c *Config) endpoint() string {
+	if c.Endpoint == "" {
+		return fmt.Sprintf("https://%s.blob.core.windows.net", c.AccountName)
+	}
+	return strings.TrimSuffix(c.Endpoint, "/")
+}
@@ -0,0 +1,148 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+// Store is a remote object storage that files can be synchronized from.
+// The file names used with the store are relative to the configured prefix.
+type Store interface {
+	// List lists all the files with their versions (e.g. generation or ETag)
+	List(ctx context.Context) (stat.Versions, error)
+	// Download writes the contents of the given file to the writer
+	Download(ctx context.Context, filename string, w io.Writer) error
+	// LogContext adds store specific details to the log context
+	LogContext(logCtx zerolog.Context) zerolog.Context
+}
+
+// Source synchronizes files from a Store to versioned directories on the local file system.
+// The latest version of the files is always available via the "latest" symlink.
+type Source struct {
+	fs              billy.Filesystem
+	store           Store
+	directory       string
+	lastVersions    stat.Versions
+	latestDirectory string
+	fileMode        os.FileMode
+	changes         changeset.ChangeSet
+}
+
+func (s *Source) Setup(fs billy.Filesystem, store Store, directory string) {
+	s.fs = fs
+	s.store = store
+	s.directory = directory
+	s.lastVersions = nil
+	s.latestDirectory = fs.Join(directory, file.LatestLinkName)
+	s.fileMode = file.DefaultFileMode
+	s.changes = nil
+}
+
+// SetFileMode sets the mode of the files written from the store (e.g. 0600 for secrets).
+func (s *Source) SetFileMode(mode os.FileMode) {
+	s.fileMode = mode
+}
+
+func (s *Source) GetDirectory() string {
+	return s.latestDirectory
+}
+
+func (s *Source) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.store.LogContext(zerolog.Ctx(ctx).With().Str("stage", "refresh")).Logger()
+	logger.Info().Msg("refreshing files from object storage")
+
+	versions, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := s.lastVersions.Changes(versions)
+	if len(changes) == 0 {
+		logger.Debug().Msg("no changes found")
+		return nil, nil
+	}
+
+	updated, existing := s.lastVersions.Updated(versions)
+	nextDirectory := s.fs.Join(s.directory, file.SnapshotName())
+	if err := file.SwapDirectory(
+		s.fs,
+		s.latestDirectory,
+		nextDirectory,
+		func(fs billy.Filesystem) error {
+			for _, filename := range updated {
+				if err := s.pullFile(ctx, fs, filename, logger); err != nil {
+					return err
+				}
+			}
+			for _, filename := range existing {
+				logger.Debug().Str("filename", filename).Msg("copying file")
+				if err := file.CopyFileWithMode(s.fs, s.fs.Join(s.latestDirectory, filename), fs, filename, s.fileMode); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	s.lastVersions = versions
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *Source) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *Source) pullFile(
+	ctx context.Context,
+	fs billy.Filesystem,
+	filename string,
+	logger zerolog.Logger,
+) error {
+	targetFile, err := file.CreateFileWithMode(fs, filename, s.fileMode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := targetFile.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close file handler")
+		}
+	}()
+
+	logger.Debug().Str("filename", filename).Msg("downloading file")
+	return s.store.Download(ctx, filename, targetFile)
+}
+
+func SanitizePrefix(prefix string) string {
+	trimmedPrefix := strings.Trim(prefix, "/")
+	if trimmedPrefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/", trimmedPrefix)
+}
+
+// KeyToFilename converts an object key to a local file name by removing the prefix from it.
+// False is returned for keys that can't be stored as files (e.g. directory placeholders).
+func KeyToFilename(prefix, key string) (string, bool) {
+	if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, "/") {
+		return "", false
+	}
+	filename := strings.TrimLeft(key[len(prefix):], "/")
+	if filename == "" || path.Clean(filename) != filename || strings.HasPrefix(filename, "../") {
+		return "", false
+	}
+	return filename, true
+}
@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+type testObject struct {
+	version string
+	content string
+}
+
+type testStore struct {
+	objects   map[string]testObject
+	downloads []string
+}
+
+func (ts *testStore) List(ctx context.Context) (stat.Versions, error) {
+	versions := make(stat.Versions, len(ts.objects))
+	for name, object := range ts.objects {
+		versions[name] = object.version
+	}
+	return versions, nil
+}
+
+func (ts *testStore) Download(ctx context.Context, filename string, w io.Writer) error {
+	object, ok := ts.objects[filename]
+	if !ok {
+		return fmt.Errorf("object %s not found", filename)
+	}
+	ts.downloads = append(ts.downloads, filename)
+	_, err := io.Copy(w, bytes.NewReader([]byte(object.content)))
+	return err
+}
+
+func (ts *testStore) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	store := &testStore{
+		objects: map[string]testObject{
+			"app.yaml":        {"1", "version: 1"},
+			"configs/db.yaml": {"1", "host: localhost"},
+		},
+	}
+	var source Source
+	source.Setup(fs, store, "blob")
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml"}, changed)
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	store.objects["app.yaml"] = testObject{"2", "version: 2"}
+	delete(store.objects, "configs/db.yaml")
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "configs/db.yaml"},
+	}, source.Changes())
+	a.Len(store.downloads, 3)
+	a.Equal("app.yaml", store.downloads[2])
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "configs/db.yaml"))
+	a.Error(err)
+}
+
+func TestSanitizePrefix(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("", SanitizePrefix(""))
+	a.Equal("", SanitizePrefix("/"))
+	a.Equal("foobar/", SanitizePrefix("/foobar/"))
+	a.Equal("foo/bar/", SanitizePrefix("/////foo/bar///"))
+}
+
+func TestKeyToFilename(t *testing.T) {
+	a := assert.New(t)
+
+	for key, expected := range map[string]string{
+		"foobar/file1":     "file1",
+		"foobar/dir/file1": "dir/file1",
+	} {
+		filename, ok := KeyToFilename("foobar/", key)
+		if a.True(ok, key) {
+			a.Equal(expected, filename)
+		}
+	}
+
+	for _, key := range []string{"foobar/", "foobar/dir/", "other/file1", "foobar/../file1", "foobar/dir//file1"} {
+		_, ok := KeyToFilename("foobar/", key)
+		a.False(ok, key)
+	}
+}
+
+func TestValues(t *testing.T) {
+	a := assert.New(t)
+	var values Values
+	values.Set(map[string][]byte{"app.yaml": []byte("version: 1")})
+
+	var buf bytes.Buffer
+	if a.NoError(values.Download(context.Background(), "app.yaml", &buf)) {
+		a.Equal("version: 1", buf.String())
+	}
+	a.Error(values.Download(context.Background(), "db.yaml", &buf))
+}
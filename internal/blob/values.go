@@ -0,0 +1,44 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Values keeps the contents of the files fetched while listing the keys of a store,
+// so that the downloads don't need extra requests. It's used by the key-value stores
+// that return the values together with the keys.
+type Values struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+// Set replaces the contents of all the files.
+func (v *Values) Set(values map[string][]byte) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values = values
+}
+
+// Download writes the contents of the given file to the writer.
+func (v *Values) Download(ctx context.Context, filename string, w io.Writer) error {
+	v.mutex.Lock()
+	value, ok := v.values[filename]
+	v.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("key for file %s not found", filename)
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+// Notify sends a notification unless one is already pending.
+func Notify(notifyCh chan<- struct{}) {
+	select {
+	case notifyCh <- struct{}{}:
+	default:
+		// Notification already pending
+	}
+}
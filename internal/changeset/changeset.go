@@ -0,0 +1,113 @@
+package changeset
+
+import (
+	"fmt"
+	"path"
+)
+
+// Kind describes how a file was changed.
+type Kind string
+
+const (
+	Added    Kind = "added"
+	Modified Kind = "modified"
+	Deleted  Kind = "deleted"
+	Renamed  Kind = "renamed"
+)
+
+func (k Kind) Validate() error {
+	switch k {
+	case Added, Modified, Deleted, Renamed:
+		return nil
+	}
+	return fmt.Errorf("invalid change kind %s", k)
+}
+
+type Change struct {
+	Kind Kind
+	Path string
+	// OldPath is the path of a renamed file before the rename
+	OldPath string
+}
+
+type ChangeSet []Change
+
+// FromPaths lists all of the paths as changes of the same kind.
+func FromPaths(kind Kind, paths []string) ChangeSet {
+	cs := make(ChangeSet, 0, len(paths))
+	for _, p := range paths {
+		cs = append(cs, Change{Kind: kind, Path: p})
+	}
+	return cs
+}
+
+// Paths lists the changed paths. Both the old and the new path are listed for renamed files.
+func (cs ChangeSet) Paths() []string {
+	paths := make([]string, 0, len(cs))
+	for _, change := range cs {
+		if change.OldPath != "" {
+			paths = append(paths, change.OldPath)
+		}
+		paths = append(paths, change.Path)
+	}
+	return paths
+}
+
+// OfKinds lists the changes of the given kinds. All of the changes are listed when no kinds are given.
+func (cs ChangeSet) OfKinds(kinds []Kind) ChangeSet {
+	if len(kinds) == 0 {
+		return cs
+	}
+	var result ChangeSet
+	for _, change := range cs {
+		for _, kind := range kinds {
+			if change.Kind == kind {
+				result = append(result, change)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Describe lists the paths as changes using the kinds found from the change set.
+// The paths missing from the change set are listed as modified files.
+// Renamed files are listed once even when both of their paths are given.
+func (cs ChangeSet) Describe(paths []string) ChangeSet {
+	byPath := make(map[string]int, len(cs))
+	for i := len(cs) - 1; i >= 0; i-- {
+		// The earliest change of the path is used
+		byPath[cs[i].Path] = i
+		if cs[i].OldPath != "" {
+			byPath[cs[i].OldPath] = i
+		}
+	}
+
+	result := make(ChangeSet, 0, len(paths))
+	described := make(map[int]bool, len(paths))
+	for _, p := range paths {
+		i, ok := byPath[p]
+		if !ok {
+			result = append(result, Change{Kind: Modified, Path: p})
+			continue
+		}
+		if !described[i] {
+			described[i] = true
+			result = append(result, cs[i])
+		}
+	}
+	return result
+}
+
+// WithPrefix prefixes all of the paths with the given path.
+func (cs ChangeSet) WithPrefix(prefix string) ChangeSet {
+	result := make(ChangeSet, 0, len(cs))
+	for _, change := range cs {
+		change.Path = path.Join(prefix, change.Path)
+		if change.OldPath != "" {
+			change.OldPath = path.Join(prefix, change.OldPath)
+		}
+		result = append(result, change)
+	}
+	return result
+}
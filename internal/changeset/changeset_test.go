@@ -0,0 +1,46 @@
+package changeset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathsAndKinds(t *testing.T) {
+	a := assert.New(t)
+	cs := ChangeSet{
+		{Kind: Added, Path: "apps/web.yaml"},
+		{Kind: Deleted, Path: "apps/db.yaml"},
+		{Kind: Renamed, Path: "apps/api.yaml", OldPath: "apps/backend.yaml"},
+	}
+
+	a.Equal([]string{"apps/web.yaml", "apps/db.yaml", "apps/backend.yaml", "apps/api.yaml"}, cs.Paths())
+	a.Equal(cs, cs.OfKinds(nil))
+	a.Equal(ChangeSet{{Kind: Deleted, Path: "apps/db.yaml"}}, cs.OfKinds([]Kind{Deleted}))
+	a.Empty(cs.OfKinds([]Kind{Modified}))
+	a.Equal(
+		[]string{"configs/apps/web.yaml", "configs/apps/db.yaml", "configs/apps/backend.yaml", "configs/apps/api.yaml"},
+		cs.WithPrefix("configs").Paths(),
+	)
+}
+
+func TestDescribe(t *testing.T) {
+	a := assert.New(t)
+	cs := ChangeSet{
+		{Kind: Added, Path: "web.yaml"},
+		{Kind: Renamed, Path: "api.yaml", OldPath: "backend.yaml"},
+		{Kind: Modified, Path: "web.yaml"},
+	}
+
+	a.Equal(ChangeSet{
+		{Kind: Added, Path: "web.yaml"},
+		{Kind: Renamed, Path: "api.yaml", OldPath: "backend.yaml"},
+		{Kind: Modified, Path: "shared/common.yaml"},
+	}, cs.Describe([]string{"web.yaml", "backend.yaml", "api.yaml", "shared/common.yaml"}))
+}
+
+func TestValidateKind(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(Deleted.Validate())
+	a.Error(Kind("removed").Validate())
+}
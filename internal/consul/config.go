@@ -0,0 +1,38 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	defaultAddress = "http://127.0.0.1:8500"
+)
+
+type Config struct {
+	Address    string               `yaml:"address,omitempty"`
+	Token      string               `yaml:"token,omitempty"`
+	Datacenter string               `yaml:"datacenter,omitempty"`
+	KeyPrefix  string               `yaml:"keyPrefix"`
+	Directory  string               `yaml:"directory"`
+	TLS        httpclient.TLSConfig `yaml:"tls,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) address() string {
+	if c.Address == "" {
+		return defaultAddress
+	}
+	return strings.TrimSuffix(c.Address, "/")
+}
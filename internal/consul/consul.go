@@ -0,0 +1,197 @@
+package consul
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/blob"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+	"gitlab.com/lepovirta/konvahti/internal/retry"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+const (
+	blockingQueryWait = "5m"
+)
+
+var (
+	watchRetryStrat = retry.ExponentialBackoff(time.Second, time.Minute)
+)
+
+type ConsulSource struct {
+	blob.Source
+	store *store
+}
+
+func (s *ConsulSource) Setup(fs billy.Filesystem, config Config) error {
+	client, err := httpclient.New(config.TLS)
+	if err != nil {
+		return err
+	}
+	s.store = &store{
+		client: client,
+		config: config,
+		prefix: blob.SanitizePrefix(config.KeyPrefix),
+	}
+	s.Source.Setup(fs, s.store, config.Directory)
+	return nil
+}
+
+// Notifications uses Consul blocking queries to find out when the keys change.
+func (s *ConsulSource) Notifications(ctx context.Context) <-chan struct{} {
+	logger := s.store.LogContext(zerolog.Ctx(ctx).With().Str("stage", "watch")).Logger()
+	notifyCh := make(chan struct{}, 1)
+	go s.store.watch(ctx, notifyCh, logger)
+	return notifyCh
+}
+
+type store struct {
+	client *http.Client
+	config Config
+	prefix string
+
+	blob.Values
+}
+
+type kvPair struct {
+	Key         string `json:"Key"`
+	Value       []byte `json:"Value"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+}
+
+func (s *store) List(ctx context.Context) (stat.Versions, error) {
+	pairs, _, err := s.query(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(stat.Versions, len(pairs))
+	values := make(map[string][]byte, len(pairs))
+	for _, pair := range pairs {
+		if filename, ok := blob.KeyToFilename(s.prefix, pair.Key); ok {
+			versions[filename] = strconv.FormatUint(pair.ModifyIndex, 10)
+			values[filename] = pair.Value
+		}
+	}
+
+	s.Set(values)
+	return versions, nil
+}
+
+// query lists the keys under the prefix. When the index is non-zero,
+// a blocking query is made, and the response is returned only after
+// the index has changed or the wait time has elapsed.
+func (s *store) query(ctx context.Context, index uint64) ([]kvPair, uint64, error) {
+	query := url.Values{}
+	query.Set("recurse", "true")
+	if s.config.Datacenter != "" {
+		query.Set("dc", s.config.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", blockingQueryWait)
+	}
+	queryURL := fmt.Sprintf(
+		"%s/v1/kv/%s?%s",
+		s.config.address(), (&url.URL{Path: s.prefix}).EscapedPath(), query.Encode(),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, queryURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.config.Token != "" {
+		req.Header.Set("X-Consul-Token", s.config.Token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	nextIndex, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		// No keys found under the prefix
+		return nil, nextIndex, nil
+	case http.StatusOK:
+		var pairs []kvPair
+		err := json.NewDecoder(res.Body).Decode(&pairs)
+		return pairs, nextIndex, err
+	default:
+		return nil, 0, fmt.Errorf("unexpected HTTP status %s from Consul", res.Status)
+	}
+}
+
+func (s *store) watch(ctx context.Context, notifyCh chan<- struct{}, logger zerolog.Logger) {
+	var index uint64
+	failures := 0
+	for ctx.Err() == nil {
+		_, nextIndex, err := s.query(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay := watchRetryStrat(failures)
+			failures++
+			logger.Warn().Err(err).Str("nextAttemptIn", delay.String()).Msg("blocking query failed")
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+		failures = 0
+
+		switch {
+		case index == 0:
+			// First query only establishes the starting point
+		case nextIndex > index:
+			logger.Debug().Uint64("consulIndex", nextIndex).Msg("keys changed")
+			blob.Notify(notifyCh)
+		case nextIndex < index:
+			// Index went backwards (e.g. Consul state was restored), so start over
+			logger.Debug().Msg("consul index reset")
+			nextIndex = 0
+		}
+		index = nextIndex
+	}
+}
+
+func (s *store) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx.
+		Str("consulAddress", s.config.address()).
+		Str("consulKeyPrefix", s.prefix)
+}
+
+// Consul encodes the values in base64. Null values are decoded as empty files.
+func (p *kvPair) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Key         string  `json:"Key"`
+		Value       *string `json:"Value"`
+		ModifyIndex uint64  `json:"ModifyIndex"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.Key = raw.Key
+	p.ModifyIndex = raw.ModifyIndex
+	p.Value = nil
+	if raw.Value != nil {
+		value, err := base64.StdEncoding.DecodeString(*raw.Value)
+		if err != nil {
+			return err
+		}
+		p.Value = value
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+// fakeConsul serves the recursive KV listing with support for blocking queries.
+type fakeConsul struct {
+	mutex   sync.Mutex
+	changed *sync.Cond
+	index   uint64
+	values  map[string]string
+	indexes map[string]uint64
+}
+
+func newFakeConsul() *fakeConsul {
+	c := &fakeConsul{
+		values:  map[string]string{},
+		indexes: map[string]uint64{},
+		index:   1,
+	}
+	c.changed = sync.NewCond(&c.mutex)
+	return c
+}
+
+func (c *fakeConsul) put(key, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.index++
+	c.values[key] = value
+	c.indexes[key] = c.index
+	c.changed.Broadcast()
+}
+
+func (c *fakeConsul) delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.index++
+	delete(c.values, key)
+	delete(c.indexes, key)
+	c.changed.Broadcast()
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for index > 0 && c.index <= index {
+		c.changed.Wait()
+	}
+
+	var pairs []map[string]interface{}
+	for key, value := range c.values {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, map[string]interface{}{
+				"Key":         key,
+				"Value":       []byte(value),
+				"ModifyIndex": c.indexes[key],
+			})
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(pairs)
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	consul := newFakeConsul()
+	consul.put("config/app/name", "konvahti")
+	consul.put("config/app/db/host", "localhost")
+	consul.put("config/other", "unrelated")
+	server := httptest.NewServer(consul)
+	defer func() {
+		// Release pending blocking queries
+		cancel()
+		consul.put("config/release", "")
+		server.Close()
+	}()
+
+	var source ConsulSource
+	if err := source.Setup(fs, Config{
+		Address:   server.URL,
+		Token:     "secret",
+		KeyPrefix: "config/app",
+		Directory: "consul",
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"name", "db/host"}, changed)
+
+	notifications := source.Notifications(log.Logger.WithContext(ctx))
+	// Give the watch time to establish the starting index
+	time.Sleep(100 * time.Millisecond)
+	consul.put("config/app/name", "konvahti2")
+	consul.delete("config/app/db/host")
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		a.Fail("no notification received")
+		return
+	}
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"name", "db/host"}, changed)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "name"))
+	if a.NoError(err) {
+		a.Equal("konvahti2", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "db/host"))
+	a.Error(err)
+}
@@ -0,0 +1,41 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	defaultEndpoint = "http://127.0.0.1:2379"
+)
+
+type Config struct {
+	Endpoint  string               `yaml:"endpoint,omitempty"`
+	Username  string               `yaml:"username,omitempty"`
+	Password  string               `yaml:"password,omitempty"`
+	KeyPrefix string               `yaml:"keyPrefix"`
+	Directory string               `yaml:"directory"`
+	TLS       httpclient.TLSConfig `yaml:"tls,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if (c.Username == "") != (c.Password == "") {
+		return fmt.Errorf("both etcd username and password must be specified")
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) endpoint() string {
+	if c.Endpoint == "" {
+		return defaultEndpoint
+	}
+	return strings.TrimSuffix(c.Endpoint, "/")
+}
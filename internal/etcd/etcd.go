@@ -0,0 +1,288 @@
+package etcd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/blob"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+	"gitlab.com/lepovirta/konvahti/internal/retry"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+var (
+	watchRetryStrat = retry.ExponentialBackoff(time.Second, time.Minute)
+)
+
+// EtcdSource synchronizes keys from etcd using the v3 JSON gRPC gateway.
+type EtcdSource struct {
+	blob.Source
+	store *store
+}
+
+func (s *EtcdSource) Setup(fs billy.Filesystem, config Config) error {
+	client, err := httpclient.New(config.TLS)
+	if err != nil {
+		return err
+	}
+	s.store = &store{
+		client: client,
+		config: config,
+		// Keys in etcd are plain byte strings, so the prefix is used as is.
+		prefix: config.KeyPrefix,
+	}
+	s.Source.Setup(fs, s.store, config.Directory)
+	return nil
+}
+
+// Notifications uses an etcd watch stream to find out when the keys change.
+func (s *EtcdSource) Notifications(ctx context.Context) <-chan struct{} {
+	logger := s.store.LogContext(zerolog.Ctx(ctx).With().Str("stage", "watch")).Logger()
+	notifyCh := make(chan struct{}, 1)
+	go s.store.watch(ctx, notifyCh, logger)
+	return notifyCh
+}
+
+type store struct {
+	client *http.Client
+	config Config
+	prefix string
+
+	blob.Values
+}
+
+type keyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type responseHeader struct {
+	Revision string `json:"revision"`
+}
+
+type rangeResponse struct {
+	Header responseHeader `json:"header"`
+	Kvs    []keyValue     `json:"kvs"`
+}
+
+type watchResponse struct {
+	Result *struct {
+		Header          responseHeader `json:"header"`
+		Created         bool           `json:"created"`
+		Canceled        bool           `json:"canceled"`
+		CompactRevision string         `json:"compact_revision"`
+		CancelReason    string         `json:"cancel_reason"`
+		Events          []struct {
+			Type string   `json:"type"`
+			Kv   keyValue `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *store) List(ctx context.Context) (stat.Versions, error) {
+	var res rangeResponse
+	if err := s.post(ctx, "/v3/kv/range", s.keyRange(), func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&res)
+	}); err != nil {
+		return nil, err
+	}
+
+	versions := make(stat.Versions, len(res.Kvs))
+	values := make(map[string][]byte, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		if filename, ok := blob.KeyToFilename(s.prefix, string(kv.Key)); ok {
+			versions[filename] = kv.ModRevision
+			values[filename] = kv.Value
+		}
+	}
+
+	s.Set(values)
+	return versions, nil
+}
+
+func (s *store) watch(ctx context.Context, notifyCh chan<- struct{}, logger zerolog.Logger) {
+	var revision int64
+	failures := 0
+	for ctx.Err() == nil {
+		nextRevision, err := s.watchStream(ctx, revision, notifyCh, logger)
+		if nextRevision > revision {
+			failures = 0
+		}
+		revision = nextRevision
+		if ctx.Err() != nil {
+			return
+		}
+		delay := watchRetryStrat(failures)
+		failures++
+		logger.Warn().Err(err).Str("nextAttemptIn", delay.String()).Msg("watch stream ended")
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watchStream watches the key range starting after the given revision,
+// and returns the last revision seen when the stream ends.
+func (s *store) watchStream(
+	ctx context.Context,
+	revision int64,
+	notifyCh chan<- struct{},
+	logger zerolog.Logger,
+) (int64, error) {
+	createRequest := s.keyRange()
+	if revision > 0 {
+		createRequest["start_revision"] = strconv.FormatInt(revision+1, 10)
+	}
+	reqBody := map[string]interface{}{"create_request": createRequest}
+
+	err := s.post(ctx, "/v3/watch", reqBody, func(body io.Reader) error {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var res watchResponse
+			if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+				return err
+			}
+			if res.Error != nil {
+				return fmt.Errorf("etcd watch failed: %s", res.Error.Message)
+			}
+			if res.Result == nil {
+				continue
+			}
+			if res.Result.Canceled {
+				if res.Result.CompactRevision != "" && res.Result.CompactRevision != "0" {
+					// Events were compacted away, so changes may have been missed
+					revision = 0
+					blob.Notify(notifyCh)
+				}
+				return fmt.Errorf("etcd watch cancelled: %s", res.Result.CancelReason)
+			}
+			if len(res.Result.Events) > 0 {
+				logger.Debug().Int("events", len(res.Result.Events)).Msg("keys changed")
+				blob.Notify(notifyCh)
+			}
+			// All the events up to the header revision have been delivered
+			if headerRevision, err := strconv.ParseInt(res.Result.Header.Revision, 10, 64); err == nil && headerRevision > revision {
+				revision = headerRevision
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	})
+	return revision, err
+}
+
+// keyRange produces the request fields for selecting all the keys under the prefix.
+func (s *store) keyRange() map[string]interface{} {
+	return map[string]interface{}{
+		"key":       []byte(s.prefix),
+		"range_end": prefixRangeEnd([]byte(s.prefix)),
+	}
+}
+
+// prefixRangeEnd returns the smallest key that is larger than all the keys with the given prefix.
+func prefixRangeEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All the keys
+	return []byte{0}
+}
+
+func (s *store) post(
+	ctx context.Context,
+	path string,
+	reqBody interface{},
+	handleBody func(io.Reader) error,
+) error {
+	var token string
+	if s.config.Username != "" {
+		var err error
+		if token, err = s.authenticate(ctx); err != nil {
+			return fmt.Errorf("etcd authentication failed: %w", err)
+		}
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.endpoint()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %s from etcd", res.Status)
+	}
+	return handleBody(res.Body)
+}
+
+func (s *store) authenticate(ctx context.Context) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"name":     s.config.Username,
+		"password": s.config.Password,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.endpoint()+"/v3/auth/authenticate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status %s", res.Status)
+	}
+	var authRes struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&authRes); err != nil {
+		return "", err
+	}
+	return authRes.Token, nil
+}
+
+func (s *store) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx.
+		Str("etcdEndpoint", s.config.endpoint()).
+		Str("etcdKeyPrefix", s.prefix)
+}
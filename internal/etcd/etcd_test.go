@@ -0,0 +1,195 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+// fakeEtcd serves a subset of the etcd v3 JSON gateway.
+type fakeEtcd struct {
+	mutex     sync.Mutex
+	revision  int64
+	values    map[string]string
+	revisions map[string]int64
+	events    chan string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		revision:  1,
+		values:    map[string]string{},
+		revisions: map[string]int64{},
+		events:    make(chan string, 10),
+	}
+}
+
+func (e *fakeEtcd) put(key, value string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.revision++
+	e.values[key] = value
+	e.revisions[key] = e.revision
+	e.events <- key
+}
+
+func (e *fakeEtcd) delete(key string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.revision++
+	delete(e.values, key)
+	delete(e.revisions, key)
+	e.events <- key
+}
+
+func (e *fakeEtcd) header() map[string]string {
+	return map[string]string{"revision": strconv.FormatInt(e.revision, 10)}
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/authenticate" {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "tokenvalue"})
+		return
+	}
+	if r.Header.Get("Authorization") != "tokenvalue" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		var kvs []map[string]interface{}
+		for key, value := range e.values {
+			if key >= string(req.Key) && key < string(req.RangeEnd) {
+				kvs = append(kvs, map[string]interface{}{
+					"key":          []byte(key),
+					"value":        []byte(value),
+					"mod_revision": strconv.FormatInt(e.revisions[key], 10),
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"header": e.header(), "kvs": kvs})
+	case "/v3/watch":
+		flusher := w.(http.Flusher)
+		e.mutex.Lock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": map[string]interface{}{"header": e.header(), "created": true},
+		})
+		e.mutex.Unlock()
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case key := <-e.events:
+				e.mutex.Lock()
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"result": map[string]interface{}{
+						"header": e.header(),
+						"events": []map[string]interface{}{{"kv": map[string]interface{}{"key": []byte(key)}}},
+					},
+				})
+				e.mutex.Unlock()
+				flusher.Flush()
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	etcd := newFakeEtcd()
+	etcd.put("/config/app/name", "konvahti")
+	etcd.put("/config/app/db/host", "localhost")
+	etcd.put("/config/other", "unrelated")
+	// Initial values are not watch events
+	for len(etcd.events) > 0 {
+		<-etcd.events
+	}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	defer cancel()
+
+	var source EtcdSource
+	if err := source.Setup(fs, Config{
+		Endpoint:  server.URL,
+		Username:  "konvahti",
+		Password:  "secret",
+		KeyPrefix: "/config/app/",
+		Directory: "etcd",
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"name", "db/host"}, changed)
+
+	notifications := source.Notifications(log.Logger.WithContext(ctx))
+	etcd.put("/config/app/name", "konvahti2")
+	etcd.delete("/config/app/db/host")
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		a.Fail("no notification received")
+		return
+	}
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"name", "db/host"}, changed)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "name"))
+	if a.NoError(err) {
+		a.Equal("konvahti2", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "db/host"))
+	a.Error(err)
+}
+
+func TestPrefixRangeEnd(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("/config0", string(prefixRangeEnd([]byte("/config/"))))
+	a.Equal("b", string(prefixRangeEnd([]byte("a\xff"))))
+	a.Equal([]byte{0}, prefixRangeEnd(nil))
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultFileMode is the mode of the created files before applying the umask
+const DefaultFileMode os.FileMode = 0666
+
+func CreateFile(fs billy.Filesystem, filename string) (billy.File, error) {
+	return CreateFileWithMode(fs, filename, DefaultFileMode)
+}
+
+func CreateFileWithMode(fs billy.Filesystem, filename string, mode os.FileMode) (billy.File, error) {
+	if err := fs.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return nil, err
+	}
+	return fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+}
+
+func WriteFile(fs billy.Filesystem, filename string, r io.Reader) error {
+	return WriteFileWithMode(fs, filename, r, DefaultFileMode)
+}
+
+func WriteFileWithMode(fs billy.Filesystem, filename string, r io.Reader, mode os.FileMode) error {
+	file, err := CreateFileWithMode(fs, filename, mode)
+	if err != nil {
+		return err
+	}
+	defer closeLogged(file, filename)
+
+	_, err = io.Copy(file, r)
+	return err
+}
+
+func CopyFile(
+	sourceFs billy.Filesystem,
+	sourceFilename string,
+	targetFs billy.Filesystem,
+	targetFilename string,
+) error {
+	return CopyFileWithMode(sourceFs, sourceFilename, targetFs, targetFilename, DefaultFileMode)
+}
+
+func CopyFileWithMode(
+	sourceFs billy.Filesystem,
+	sourceFilename string,
+	targetFs billy.Filesystem,
+	targetFilename string,
+	mode os.FileMode,
+) error {
+	sourceFile, err := sourceFs.Open(sourceFilename)
+	if err != nil {
+		return err
+	}
+	defer closeLogged(sourceFile, sourceFilename)
+
+	return WriteFileWithMode(targetFs, targetFilename, sourceFile, mode)
+}
+
+func closeLogged(file io.Closer, filename string) {
+	if err := file.Close(); err != nil {
+		log.Error().
+			Str("filename", filename).
+			Err(err).
+			Msg("failed to close file")
+	}
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+func HashFile(fs billy.Filesystem, filename string) (string, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer closeLogged(f, filename)
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HashDirectory calculates SHA-256 hashes for all the regular files in the directory tree.
+// The hashes are keyed by the file path relative to the directory.
+// An empty result is returned when the directory doesn't exist.
+func HashDirectory(fs billy.Filesystem, directory string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := hashDirectory(fs, directory, "", hashes)
+	if os.IsNotExist(err) {
+		return hashes, nil
+	}
+	return hashes, err
+}
+
+func hashDirectory(fs billy.Filesystem, directory, relPath string, hashes map[string]string) error {
+	entries, err := fs.ReadDir(fs.Join(directory, relPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := path.Join(relPath, entry.Name())
+		switch {
+		case entry.IsDir():
+			if err := hashDirectory(fs, directory, entryPath, hashes); err != nil {
+				return err
+			}
+		case entry.Mode().IsRegular():
+			hash, err := HashFile(fs, fs.Join(directory, entryPath))
+			if err != nil {
+				return err
+			}
+			hashes[entryPath] = hash
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashDirectory(t *testing.T) {
+	a := assert.New(t)
+	fs := memfs.New()
+
+	for _, testFile := range testFiles {
+		if err := util.WriteFile(fs, fs.Join("stuff", testFile.name), testFile.data, 0660); !a.NoError(err) {
+			return
+		}
+	}
+
+	hashes, err := HashDirectory(fs, "stuff")
+	if !a.NoError(err) {
+		return
+	}
+	a.Len(hashes, len(testFiles))
+	a.Equal("c0535e4be2b79ffd93291305436bf889314e4a3faec05ecffcbb7df31ad9e51a", hashes["texts/hello.txt"])
+	a.NotEqual(hashes["texts/hello.txt"], hashes["texts/goodbye.txt"])
+
+	hashes, err = HashDirectory(fs, "missing")
+	if a.NoError(err) {
+		a.Empty(hashes)
+	}
+}
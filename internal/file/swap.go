@@ -3,8 +3,10 @@ package file
 import (
 	// "os"
 
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
@@ -12,11 +14,19 @@ import (
 )
 
 const (
-	linkSuffix = "_ln"
+	linkSuffix     = "_ln"
+	LatestLinkName = "latest"
 )
 
 type DirectoryPopulator func(billy.Filesystem)error
 
+// SnapshotName generates a name for a new directory to swap in.
+// Nanosecond precision is used, so that refreshes following each other
+// quickly don't end up overwriting the directory currently in use.
+func SnapshotName() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
 func SwapDirectory(
 	fs billy.Filesystem,
 	targetDirectoryLink string,
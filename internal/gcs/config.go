@@ -0,0 +1,42 @@
+package gcs
+
+import (
+	"fmt"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+)
+
+type Config struct {
+	Endpoint        string `yaml:"endpoint,omitempty"`
+	BucketName      string `yaml:"bucketName"`
+	BucketPrefix    string `yaml:"bucketPrefix"`
+	Directory       string `yaml:"directory"`
+	CredentialsFile string `yaml:"credentialsFile,omitempty"`
+	CredentialsJSON string `yaml:"credentialsJson,omitempty"`
+	DisableAuth     bool   `yaml:"disableAuth,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.BucketName == "" {
+		return fmt.Errorf("no GCS bucket name specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if c.CredentialsFile != "" && c.CredentialsJSON != "" {
+		return fmt.Errorf("both GCS credentials file and credentials JSON specified")
+	}
+	if c.DisableAuth && (c.CredentialsFile != "" || c.CredentialsJSON != "") {
+		return fmt.Errorf("GCS credentials specified while authentication is disabled")
+	}
+	return nil
+}
+
+func (c *Config) endpoint() string {
+	if c.Endpoint == "" {
+		return defaultEndpoint
+	}
+	return c.Endpoint
+}
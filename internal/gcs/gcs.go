@@ -0,0 +1,191 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/blob"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	readOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+)
+
+type GCSSource struct {
+	blob.Source
+}
+
+func (s *GCSSource) Setup(fs billy.Filesystem, config Config) error {
+	store, err := newStore(config)
+	if err != nil {
+		return err
+	}
+	s.Source.Setup(fs, store, config.Directory)
+	return nil
+}
+
+type store struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+	// The generations from the latest listing are downloaded,
+	// so that the files match the versions recorded for them.
+	mutex       sync.Mutex
+	generations stat.Versions
+}
+
+func newStore(config Config) (*store, error) {
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &store{
+		client:   client,
+		endpoint: strings.TrimSuffix(config.endpoint(), "/"),
+		bucket:   config.BucketName,
+		prefix:   blob.SanitizePrefix(config.BucketPrefix),
+	}, nil
+}
+
+// newClient creates a HTTP client that authenticates the requests using the
+// configured credentials, or using the application default credentials
+// (e.g. GOOGLE_APPLICATION_CREDENTIALS or the metadata server) when none are configured.
+func newClient(config Config) (*http.Client, error) {
+	if config.DisableAuth {
+		return &http.Client{}, nil
+	}
+
+	ctx := context.Background()
+	credentialsJSON := []byte(config.CredentialsJSON)
+	if config.CredentialsFile != "" {
+		var err error
+		credentialsJSON, err = os.ReadFile(filepath.Clean(config.CredentialsFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GCS credentials file: %w", err)
+		}
+	}
+
+	var credentials *google.Credentials
+	var err error
+	if len(credentialsJSON) > 0 {
+		credentials, err = google.CredentialsFromJSON(ctx, credentialsJSON, readOnlyScope)
+	} else {
+		credentials, err = google.FindDefaultCredentials(ctx, readOnlyScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GCS credentials: %w", err)
+	}
+	return oauth2.NewClient(ctx, credentials.TokenSource), nil
+}
+
+type listResponse struct {
+	NextPageToken string `json:"nextPageToken"`
+	Items         []struct {
+		Name       string `json:"name"`
+		Generation string `json:"generation"`
+	} `json:"items"`
+}
+
+func (s *store) List(ctx context.Context) (stat.Versions, error) {
+	versions := make(stat.Versions, 100)
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", s.prefix)
+		query.Set("fields", "nextPageToken,items(name,generation)")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		listURL := fmt.Sprintf(
+			"%s/storage/v1/b/%s/o?%s",
+			s.endpoint, url.PathEscape(s.bucket), query.Encode(),
+		)
+
+		var res listResponse
+		if err := s.getJSON(ctx, listURL, &res); err != nil {
+			return nil, err
+		}
+		for _, item := range res.Items {
+			if filename, ok := blob.KeyToFilename(s.prefix, item.Name); ok {
+				versions[filename] = item.Generation
+			}
+		}
+
+		if res.NextPageToken == "" {
+			s.mutex.Lock()
+			s.generations = versions
+			s.mutex.Unlock()
+			return versions, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+func (s *store) Download(ctx context.Context, filename string, w io.Writer) error {
+	query := url.Values{}
+	query.Set("alt", "media")
+	s.mutex.Lock()
+	generation, ok := s.generations[filename]
+	s.mutex.Unlock()
+	if ok {
+		query.Set("generation", generation)
+	}
+	downloadURL := fmt.Sprintf(
+		"%s/storage/v1/b/%s/o/%s?%s",
+		s.endpoint, url.PathEscape(s.bucket), url.PathEscape(s.prefix+filename), query.Encode(),
+	)
+	res, err := s.get(ctx, downloadURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (s *store) getJSON(ctx context.Context, u string, v interface{}) error {
+	res, err := s.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (s *store) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s from GCS", res.Status)
+	}
+	return res, nil
+}
+
+func (s *store) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx.
+		Str("gcsEndpoint", s.endpoint).
+		Str("gcsBucketName", s.bucket).
+		Str("gcsBucketPrefix", s.prefix)
+}
@@ -0,0 +1,127 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+type testObject struct {
+	generation string
+	content    string
+}
+
+// fakeGCS serves a subset of the GCS JSON API. Each list page contains one object.
+// The objects are only downloaded with their current generation.
+func fakeGCS(t *testing.T, bucket string, objects map[string]testObject, names []string) http.Handler {
+	listPath := "/storage/v1/b/" + bucket + "/o"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == listPath:
+			var matching []string
+			for _, name := range names {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					matching = append(matching, name)
+				}
+			}
+			page := 0
+			if token := r.URL.Query().Get("pageToken"); token != "" {
+				for i, name := range matching {
+					if name == token {
+						page = i
+					}
+				}
+			}
+			res := map[string]interface{}{}
+			if page < len(matching) {
+				name := matching[page]
+				res["items"] = []map[string]string{{
+					"name":       name,
+					"generation": objects[name].generation,
+				}}
+				if page+1 < len(matching) {
+					res["nextPageToken"] = matching[page+1]
+				}
+			}
+			if err := json.NewEncoder(w).Encode(res); err != nil {
+				t.Error(err)
+			}
+		case strings.HasPrefix(r.URL.Path, listPath+"/") && r.URL.Query().Get("alt") == "media":
+			object, ok := objects[strings.TrimPrefix(r.URL.Path, listPath+"/")]
+			if !ok || r.URL.Query().Get("generation") != object.generation {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(object.content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	objects := map[string]testObject{
+		"configs/":             {"1", ""},
+		"configs/app.yaml":     {"1", "version: 1"},
+		"configs/sub/db.yaml":  {"1", "host: localhost"},
+		"other/unrelated.yaml": {"1", "unrelated: true"},
+	}
+	names := []string{"configs/", "configs/app.yaml", "configs/sub/db.yaml", "other/unrelated.yaml"}
+	server := httptest.NewServer(fakeGCS(t, "bukit", objects, names))
+	defer server.Close()
+
+	var source GCSSource
+	if err := source.Setup(fs, Config{
+		Endpoint:     server.URL,
+		BucketName:   "bukit",
+		BucketPrefix: "/configs/",
+		Directory:    "gcs",
+		DisableAuth:  true,
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "sub/db.yaml"}, changed)
+
+	objects["configs/app.yaml"] = testObject{"2", "version: 2"}
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal([]string{"app.yaml"}, changed)
+
+	for filename, content := range map[string]string{
+		"app.yaml":    "version: 2",
+		"sub/db.yaml": "host: localhost",
+	} {
+		data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), filename))
+		if a.NoError(err) {
+			a.Equal(content, string(data))
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+const (
+	bundleSignatureV2 = "# v2 git bundle"
+	bundleSignatureV3 = "# v3 git bundle"
+	bundleFileSuffix  = ".bundle"
+)
+
+// BundleSource imports commits from git bundle files instead of fetching them from a remote.
+// This allows using Git sources on hosts that don't have network access to a Git server.
+type BundleSource struct {
+	config     BundleConfig
+	repository *git.Repository
+	changes    changeset.ChangeSet
+}
+
+func (bs *BundleSource) Setup(config BundleConfig) error {
+	bs.config = config
+	bs.repository = nil
+	return nil
+}
+
+func (bs *BundleSource) GetDirectory() string {
+	return bs.config.Directory
+}
+
+func (bs *BundleSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := bs.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("refreshing files from Git bundles")
+	bs.changes = nil
+
+	if bs.repository == nil {
+		repo, err := openOrInitRepository(bs.config.Directory)
+		if err != nil {
+			return nil, err
+		}
+		bs.repository = repo
+	}
+
+	bundleFiles, err := listBundleFiles(bs.config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bundles are applied in the order of their names, so the tip of the last imported bundle
+	// is the commit to check out.
+	branchRef := plumbing.NewBranchReferenceName(bs.config.Branch)
+	var target plumbing.Hash
+	for _, bundleFile := range bundleFiles {
+		bundleLogger := logger.With().Str("gitBundle", bundleFile).Logger()
+		tip, err := bs.importBundle(bundleFile, branchRef, bundleLogger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import bundle %s: %w", bundleFile, err)
+		}
+		if !tip.IsZero() {
+			target = tip
+		}
+	}
+	if target.IsZero() {
+		logger.Debug().Msg("no new bundles found")
+		return nil, nil
+	}
+
+	prevHead, err := bs.repository.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		prevHead = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if prevHead != nil && prevHead.Hash() == target {
+		logger.Debug().Msg("no changes found")
+		return nil, nil
+	}
+
+	logger.Debug().Str("gitHashNext", target.String()).Msg("checking out bundle tip")
+	if err := checkoutBranch(bs.repository, branchRef, target); err != nil {
+		return nil, err
+	}
+
+	if prevHead == nil {
+		files, err := gitListCurrentFiles(bs.repository)
+		if err != nil {
+			return nil, err
+		}
+		bs.changes = changeset.FromPaths(changeset.Added, files)
+		return files, nil
+	}
+	changes, err := gitListChanges(ctx, bs.repository, prevHead, logger)
+	if err != nil {
+		return nil, err
+	}
+	bs.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, deleted, or renamed on the latest refresh.
+func (bs *BundleSource) Changes() changeset.ChangeSet {
+	return bs.changes
+}
+
+// importBundle imports the objects from the bundle to the repository, and returns the tip of the branch.
+// Zero hash is returned when the bundle doesn't contain the branch or when it's already applied.
+func (bs *BundleSource) importBundle(
+	filename string,
+	branchRef plumbing.ReferenceName,
+	logger zerolog.Logger,
+) (tip plumbing.Hash, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	r := bufio.NewReader(f)
+	header, err := readBundleHeader(r)
+	if err != nil {
+		return
+	}
+
+	tip, ok := header.references[branchRef]
+	if !ok {
+		logger.Warn().Str("gitRef", branchRef.String()).Msg("branch not found from bundle")
+		return plumbing.ZeroHash, nil
+	}
+	if bs.isApplied(branchRef, tip) {
+		logger.Debug().Str("gitHash", tip.String()).Msg("bundle already applied")
+		return plumbing.ZeroHash, nil
+	}
+
+	for _, prerequisite := range header.prerequisites {
+		if bs.repository.Storer.HasEncodedObject(prerequisite) != nil {
+			return plumbing.ZeroHash, fmt.Errorf("bundle requires commit %s, which is not found", prerequisite)
+		}
+	}
+
+	logger.Info().Str("gitHash", tip.String()).Msg("importing bundle")
+	// Bundles usually contain thin packs where the objects are deltas of the prerequisite objects.
+	// The parser resolves those from the repository storage.
+	parser, err := packfile.NewParserWithStorage(packfile.NewScanner(r), bs.repository.Storer)
+	if err != nil {
+		return
+	}
+	if _, err = parser.Parse(); err != nil {
+		return
+	}
+	return tip, nil
+}
+
+// isApplied checks whether the commit is already part of the branch history.
+func (bs *BundleSource) isApplied(branchRef plumbing.ReferenceName, commitHash plumbing.Hash) bool {
+	ref, err := bs.repository.Reference(branchRef, true)
+	if err != nil {
+		return false
+	}
+	if ref.Hash() == commitHash {
+		return true
+	}
+	commit, err := bs.repository.CommitObject(commitHash)
+	if err != nil {
+		return false
+	}
+	branchCommit, err := bs.repository.CommitObject(ref.Hash())
+	if err != nil {
+		return false
+	}
+	isAncestor, err := commit.IsAncestor(branchCommit)
+	return err == nil && isAncestor
+}
+
+func (bs *BundleSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	var currentCommitHash string
+	if bs.repository != nil {
+		if ref, err := bs.repository.Head(); err == nil {
+			currentCommitHash = ref.Hash().String()
+		}
+	}
+
+	return logger.With().
+		Str("stage", "refresh").
+		Str("gitBundlePath", bs.config.Path).
+		Str("gitBranch", bs.config.Branch).
+		Str("gitHash", currentCommitHash).
+		Logger()
+}
+
+func openOrInitRepository(directory string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(directory)
+	if err == git.ErrRepositoryNotExists {
+		return git.PlainInit(directory, false)
+	}
+	return repo, err
+}
+
+// listBundleFiles lists the bundle files sorted by name when the path is a directory.
+func listBundleFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), bundleFileSuffix) {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type bundleHeader struct {
+	prerequisites []plumbing.Hash
+	references    map[plumbing.ReferenceName]plumbing.Hash
+}
+
+// readBundleHeader reads the bundle header, and leaves the reader at the start of the packfile.
+func readBundleHeader(r *bufio.Reader) (*bundleHeader, error) {
+	signature, err := readBundleLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if signature != bundleSignatureV2 && signature != bundleSignatureV3 {
+		return nil, fmt.Errorf("unsupported bundle format: %s", signature)
+	}
+
+	header := &bundleHeader{references: map[plumbing.ReferenceName]plumbing.Hash{}}
+	for {
+		line, err := readBundleLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "":
+			return header, nil
+		case strings.HasPrefix(line, "@"):
+			// Capabilities are only found from v3 bundles
+			if line != "@object-format=sha1" {
+				return nil, fmt.Errorf("unsupported bundle capability: %s", line)
+			}
+		case strings.HasPrefix(line, "-"):
+			hash, _, err := parseBundleHash(strings.TrimPrefix(line, "-"))
+			if err != nil {
+				return nil, err
+			}
+			header.prerequisites = append(header.prerequisites, hash)
+		default:
+			hash, name, err := parseBundleHash(line)
+			if err != nil {
+				return nil, err
+			}
+			header.references[plumbing.ReferenceName(name)] = hash
+		}
+	}
+}
+
+func readBundleLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("invalid bundle header: %w", err)
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func parseBundleHash(line string) (plumbing.Hash, string, error) {
+	hashStr, rest := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		hashStr, rest = line[:i], line[i+1:]
+	}
+	if len(hashStr) != 40 {
+		return plumbing.ZeroHash, "", fmt.Errorf("invalid object ID in bundle header: %s", hashStr)
+	}
+	return plumbing.NewHash(hashStr), rest, nil
+}
@@ -0,0 +1,177 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitFiles(t *testing.T, repo *git.Repository, dir string, files map[string]string) plumbing.Hash {
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, content := range files {
+		path := filepath.Join(dir, filename)
+		if content == "" {
+			if _, err := wt.Remove(filename); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := wt.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "Konvahti", Email: "konvahti@example.org", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// writeBundle writes a bundle that contains the objects reachable from the tip
+// excluding the objects reachable from the prerequisites.
+func writeBundle(t *testing.T, repo *git.Repository, filename string, tip plumbing.Hash, prerequisites ...plumbing.Hash) {
+	hashes, err := revlist.Objects(repo.Storer, []plumbing.Hash{tip}, prerequisites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	header := bundleSignatureV2 + "\n"
+	for _, prerequisite := range prerequisites {
+		header += fmt.Sprintf("-%s some commit\n", prerequisite)
+	}
+	header += fmt.Sprintf("%s refs/heads/main\n\n", tip)
+	if _, err := f.WriteString(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := packfile.NewEncoder(f, repo.Storer, false).Encode(hashes, 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundleRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	bundleDir := t.TempDir()
+	targetDir := filepath.Join(t.TempDir(), "repo")
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{
+		"app.yaml":        "version: 1",
+		"configs/db.yaml": "host: localhost",
+	})
+	writeBundle(t, upstream, filepath.Join(bundleDir, "0001.bundle"), first)
+
+	var source BundleSource
+	if err := source.Setup(BundleConfig{
+		Path:      bundleDir,
+		Branch:    "main",
+		Directory: targetDir,
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	// The initial file list includes directories just like with cloned repositories
+	a.ElementsMatch([]string{"app.yaml", "configs", "configs/db.yaml"}, changed)
+
+	// Already applied bundles are skipped
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{
+		"app.yaml":        "version: 2",
+		"configs/db.yaml": "",
+	})
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{
+		"new.yaml": "new: true",
+	})
+	writeBundle(t, upstream, filepath.Join(bundleDir, "0002.bundle"), second, first)
+	writeBundle(t, upstream, filepath.Join(bundleDir, "0003.bundle"), third, second)
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml", "new.yaml"}, changed)
+
+	data, err := os.ReadFile(filepath.Join(targetDir, "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+	_, err = os.Stat(filepath.Join(targetDir, "configs/db.yaml"))
+	a.True(os.IsNotExist(err))
+
+	// A new source instance finds the repository from the file system
+	var restarted BundleSource
+	if err := restarted.Setup(source.config); !a.NoError(err) {
+		return
+	}
+	changed, err = restarted.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+}
+
+func TestBundleMissingPrerequisite(t *testing.T) {
+	a := assert.New(t)
+	upstreamDir := t.TempDir()
+	bundleFile := filepath.Join(t.TempDir(), "update.bundle")
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	writeBundle(t, upstream, bundleFile, second, first)
+
+	var source BundleSource
+	if err := source.Setup(BundleConfig{
+		Path:      bundleFile,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	_, err = source.Refresh(context.Background())
+	a.Error(err)
+}
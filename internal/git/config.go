@@ -2,28 +2,115 @@ package git
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+	"gitlab.com/lepovirta/konvahti/internal/sshauth"
+)
+
+const (
+	// UpdateStrategyFastForward accepts only fast-forward updates to the branch
+	UpdateStrategyFastForward = "fastForward"
+	// UpdateStrategyReset resets the branch to the remote branch even when the history has been rewritten
+	UpdateStrategyReset = "reset"
+)
+
+const (
+	defaultDepth = 10
+	// FullDepth fetches the full history
+	FullDepth = -1
 )
 
 type Config struct {
-	URL       string      `yaml:"url"`
-	Branch    string      `yaml:"branch"`
-	Directory string      `yaml:"directory"`
-	HTTPAuth  GitHTTPAuth `yaml:"httpAuth,omitempty"`
-	SSHAuth   GitSSHAuth  `yaml:"sshAuth,omitempty"`
+	URL            string               `yaml:"url"`
+	Branch         string               `yaml:"branch,omitempty"`
+	Tag            string               `yaml:"tag,omitempty"`
+	Pointer        GitPointer           `yaml:"pointer,omitempty"`
+	Signatures     GitSignatures        `yaml:"signatures,omitempty"`
+	Submodules     bool                 `yaml:"submodules,omitempty"`
+	IncludePaths   []string             `yaml:"includePaths,omitempty"`
+	LFS            GitLFS               `yaml:"lfs,omitempty"`
+	UpdateStrategy string               `yaml:"updateStrategy,omitempty"`
+	Depth          int                  `yaml:"depth,omitempty"`
+	Replay         bool                 `yaml:"replay,omitempty"`
+	Directory      string               `yaml:"directory"`
+	Snapshots      bool                 `yaml:"snapshots,omitempty"`
+	HTTPAuth       GitHTTPAuth          `yaml:"httpAuth,omitempty"`
+	TLS            httpclient.TLSConfig `yaml:"tls,omitempty"`
+	ProxyURL       string               `yaml:"proxyURL,omitempty"`
+	SSHAuth        GitSSHAuth           `yaml:"sshAuth,omitempty"`
 }
 
 func (c *Config) Validate() error {
 	if c.URL == "" {
 		return fmt.Errorf("no Git URL specified")
 	}
-	if c.Branch == "" {
-		return fmt.Errorf("no Git branch specified")
+	targets := 0
+	for _, isSet := range []bool{c.Branch != "", c.Tag != "", c.Pointer.Ref != ""} {
+		if isSet {
+			targets++
+		}
+	}
+	if targets == 0 {
+		return fmt.Errorf("no Git branch, tag, or pointer ref specified")
+	}
+	if targets > 1 {
+		return fmt.Errorf("only one of Git branch, tag, or pointer ref can be specified")
+	}
+	if err := c.Pointer.validate(c.Branch); err != nil {
+		return err
+	}
+	if c.Signatures.AllCommits && !c.Signatures.isSet() {
+		return fmt.Errorf("no trusted keys specified for Git signature verification")
+	}
+	if len(c.IncludePaths) > 0 && c.Submodules {
+		return fmt.Errorf("include paths can't be used with Git submodules")
+	}
+	for _, p := range c.IncludePaths {
+		if strings.Trim(p, "/") == "" {
+			return fmt.Errorf("empty Git include path specified")
+		}
+	}
+	if c.Depth < FullDepth {
+		return fmt.Errorf("invalid Git depth %d", c.Depth)
+	}
+	switch c.UpdateStrategy {
+	case "", UpdateStrategyFastForward, UpdateStrategyReset:
+	default:
+		return fmt.Errorf("invalid Git update strategy %s", c.UpdateStrategy)
+	}
+	if c.Replay && c.Tag != "" {
+		return fmt.Errorf("replaying Git commits can't be used with tags")
+	}
+	if c.LFS.Enabled && c.LFS.URL == "" {
+		if _, err := lfsEndpoint(c.URL); err != nil {
+			return fmt.Errorf("no Git LFS URL specified: %w", err)
+		}
+	}
+	if c.Tag != "" {
+		if _, err := newTagMatcher(c.Tag); err != nil {
+			return err
+		}
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config: %w", err)
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid Git proxy URL %s", c.ProxyURL)
+		}
+	}
+	if c.SSHAuth.HasCredentials() {
+		if err := c.SSHAuth.Validate(); err != nil {
+			return fmt.Errorf("invalid SSH auth: %w", err)
+		}
 	}
 	if c.Directory == "" {
 		return fmt.Errorf("no local directory specified")
@@ -31,23 +118,48 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-func (c *Config) toCloneOptions(cloneOptions *git.CloneOptions) error {
-	authMethod, err := c.authMethod()
+// hasHTTPSettings returns true when the default HTTP client can't be used for the Git HTTP transport.
+func (c *Config) hasHTTPSettings() bool {
+	return c.TLS != (httpclient.TLSConfig{}) || c.ProxyURL != ""
+}
+
+// httpClient creates the HTTP client for the Git HTTP transport and for Git LFS.
+func (c *Config) httpClient() (*http.Client, error) {
+	client, err := httpclient.New(c.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return withProxy(client, c.ProxyURL)
+}
+
+func (c *Config) toCloneOptions(cloneOptions *git.CloneOptions, sshAgent *sshauth.Agent) error {
+	authMethod, err := c.authMethod(sshAgent)
 	if err != nil {
 		return err
 	}
 
 	cloneOptions.URL = c.URL
-	cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(c.Branch)
+	if c.Branch != "" {
+		// With tags, the reference is picked from the remote tags before cloning
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(c.Branch)
+	}
 	cloneOptions.SingleBranch = true
-	cloneOptions.Depth = 10
+	switch c.Depth {
+	case 0:
+		cloneOptions.Depth = defaultDepth
+	case FullDepth:
+		// Zero depth fetches the full history
+		cloneOptions.Depth = 0
+	default:
+		cloneOptions.Depth = c.Depth
+	}
 	cloneOptions.Tags = git.NoTags
 	cloneOptions.Auth = authMethod
 
 	return cloneOptions.Validate()
 }
 
-func (c *Config) authMethod() (transport.AuthMethod, error) {
+func (c *Config) authMethod(sshAgent *sshauth.Agent) (transport.AuthMethod, error) {
 	if c.HTTPAuth.Token != "" {
 		return &githttp.TokenAuth{
 			Token: c.HTTPAuth.Token,
@@ -61,21 +173,127 @@ func (c *Config) authMethod() (transport.AuthMethod, error) {
 		}, nil
 	}
 
-	if c.SSHAuth.KeyPath != "" {
-		return gitssh.NewPublicKeysFromFile(c.SSHAuth.Username, c.SSHAuth.KeyPath, c.SSHAuth.KeyPassword)
+	if c.SSHAuth.HasCredentials() {
+		return c.sshAuthMethod(sshAgent)
 	}
 
 	return nil, nil
 }
 
+func (c *Config) sshAuthMethod(sshAgent *sshauth.Agent) (transport.AuthMethod, error) {
+	// When no known hosts file is specified, the Git SSH transport falls back
+	// to the default known hosts files.
+	hostKeyCallback, err := c.SSHAuth.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallbackHelper := gitssh.HostKeyCallbackHelper{
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	if c.SSHAuth.KeyPath == "" && c.SSHAuth.Key == "" {
+		if sshAgent != nil {
+			return &gitssh.PublicKeysCallback{
+				User:                  c.SSHAuth.Username,
+				Callback:              sshAgent.Signers,
+				HostKeyCallbackHelper: hostKeyCallbackHelper,
+			}, nil
+		}
+		return &gitssh.Password{
+			User:                  c.SSHAuth.Username,
+			Password:              c.SSHAuth.Password,
+			HostKeyCallbackHelper: hostKeyCallbackHelper,
+		}, nil
+	}
+
+	signer, err := c.SSHAuth.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return &gitssh.PublicKeys{
+		User:                  c.SSHAuth.Username,
+		Signer:                signer,
+		HostKeyCallbackHelper: hostKeyCallbackHelper,
+	}, nil
+}
+
 type GitHTTPAuth struct {
 	Token    string `yaml:"token"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 }
 
-type GitSSHAuth struct {
-	Username    string `yaml:"username"`
-	KeyPath     string `yaml:"keyPath"`
-	KeyPassword string `yaml:"keyPassword"`
+type GitSSHAuth = sshauth.Config
+
+// GitPointer specifies where to find the commit to check out.
+// The commit can be pointed by a ref in the repository, or by a file in a branch of
+// another repository that contains the commit hash.
+type GitPointer struct {
+	Ref    string `yaml:"ref,omitempty"`
+	URL    string `yaml:"url,omitempty"`
+	Branch string `yaml:"branch,omitempty"`
+	File   string `yaml:"file,omitempty"`
+}
+
+func (p *GitPointer) isSet() bool {
+	return p.Ref != "" || p.File != ""
+}
+
+func (p *GitPointer) validate(branch string) error {
+	if p.Ref != "" && p.File != "" {
+		return fmt.Errorf("only one of Git pointer ref or file can be specified")
+	}
+	if p.Ref != "" && !strings.HasPrefix(p.Ref, "refs/") {
+		return fmt.Errorf("pointer ref %s doesn't start with refs/", p.Ref)
+	}
+	if p.File != "" {
+		if branch == "" {
+			return fmt.Errorf("no Git branch specified for fetching the commits of the pointer file")
+		}
+		if p.Branch == "" {
+			return fmt.Errorf("no branch specified for the Git pointer file")
+		}
+	}
+	if !p.isSet() && (p.URL != "" || p.Branch != "") {
+		return fmt.Errorf("no Git pointer ref or file specified")
+	}
+	return nil
+}
+
+// GitLFS enables fetching the Git LFS objects for the LFS pointer files.
+// By default, the LFS server URL is derived from the repository URL.
+type GitLFS struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url,omitempty"`
+}
+
+type BundleConfig struct {
+	Path      string `yaml:"path"`
+	Branch    string `yaml:"branch"`
+	Directory string `yaml:"directory"`
+}
+
+func (c *BundleConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("no Git bundle path specified")
+	}
+	if c.Branch == "" {
+		return fmt.Errorf("no Git branch specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	return nil
+}
+
+// GitSignatures specifies the trusted keys for verifying the commit signatures.
+// When no keys are specified, the signatures are not verified.
+type GitSignatures struct {
+	PGPKeyringPath        string `yaml:"pgpKeyringPath"`
+	SSHAllowedSignersPath string `yaml:"sshAllowedSignersPath"`
+	AllCommits            bool   `yaml:"allCommits"`
+}
+
+func (s *GitSignatures) isSet() bool {
+	return s.PGPKeyringPath != "" || s.SSHAllowedSignersPath != ""
 }
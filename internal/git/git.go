@@ -2,12 +2,27 @@ package git
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
 
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/sshauth"
 )
 
 type GitSource struct {
@@ -15,16 +30,80 @@ type GitSource struct {
 	cloneOptions   git.CloneOptions
 	pullOptions    git.PullOptions
 	repository     *git.Repository
+	tagMatcher     *tagMatcher
+	verifier       *signatureVerifier
+	pathFilter     pathFilter
+	lfsClient      *lfsClient
+	sshAgent       *sshauth.Agent
+	sparseSynced   bool
+	restoredFiles  []string
+	snapshotFs     billy.Filesystem
+	snapshotFiles  []string
+	pendingChanges changeset.ChangeSet
+	changes        changeset.ChangeSet
+	tag            string
+	previousTag    string
+	previousHash   plumbing.Hash
+	// Replay state
+	replayPlan        replayPlan
+	replayStepPending bool
+	replayStepHash    plumbing.Hash
+	replayStepFiles   []string
+	replayRemaining   bool
 }
 
 func (gs *GitSource) Setup(config Config) error {
 	gs.config = config
-	if err := config.toCloneOptions(&gs.cloneOptions); err != nil {
+	if config.Snapshots {
+		gs.snapshotFs = osfs.New(config.Directory)
+		gs.config.Directory = filepath.Join(config.Directory, snapshotRepositoryDirectory)
+	}
+	if config.SSHAuth.UseAgent {
+		gs.sshAgent = &sshauth.Agent{}
+	}
+	if err := config.toCloneOptions(&gs.cloneOptions, gs.sshAgent); err != nil {
 		return err
 	}
+	if config.Tag != "" {
+		matcher, err := newTagMatcher(config.Tag)
+		if err != nil {
+			return err
+		}
+		gs.tagMatcher = matcher
+	}
+	if config.Signatures.isSet() {
+		verifier, err := newSignatureVerifier(&config.Signatures)
+		if err != nil {
+			return err
+		}
+		gs.verifier = verifier
+	}
+	gs.pathFilter = newPathFilter(config.IncludePaths)
+	httpClient, err := config.httpClient()
+	if err != nil {
+		return err
+	}
+	if config.hasHTTPSettings() {
+		gs.cloneOptions.Auth = withHTTPClient(gs.cloneOptions.Auth, httpClient)
+	}
+	if config.LFS.Enabled {
+		client, err := newLFSClient(&config, httpClient)
+		if err != nil {
+			return err
+		}
+		gs.lfsClient = client
+	}
 	return cloneOptionsToPullOptions(&gs.pullOptions, &gs.cloneOptions)
 }
 
+// Close closes the connection to the SSH agent.
+func (gs *GitSource) Close() error {
+	if gs.sshAgent == nil {
+		return nil
+	}
+	return gs.sshAgent.Close()
+}
+
 func cloneOptionsToPullOptions(p *git.PullOptions, o *git.CloneOptions) error {
 	p.RemoteName = o.RemoteName
 	p.ReferenceName = o.ReferenceName
@@ -41,6 +120,37 @@ func (gs *GitSource) clone(ctx context.Context) (*git.Repository, error) {
 	return repo, nil
 }
 
+// initRepository creates an empty repository, where the commits are fetched to
+// when the commit to check out is resolved before checking it out.
+func (gs *GitSource) initRepository() (*git.Repository, error) {
+	repo, err := git.PlainInit(gs.config.Directory, false)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gs.config.URL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// resolvesTarget returns true when the commit to check out is resolved before checking it out
+// instead of pulling the branch. This is the case with tags and pointers, when the commits
+// must be verified before they are checked out, when only some of the paths are checked out,
+// when the LFS objects replace the pointer files in the working directory,
+// and when the branch is reset to the remote branch instead of pulling it.
+func (gs *GitSource) resolvesTarget() bool {
+	return gs.tagMatcher != nil || gs.config.Pointer.isSet() || gs.verifier != nil || gs.isSparse() ||
+		gs.lfsClient != nil || gs.config.UpdateStrategy == UpdateStrategyReset || gs.config.Replay
+}
+
+func (gs *GitSource) isSparse() bool {
+	return len(gs.pathFilter) > 0
+}
+
 func (gs *GitSource) pull(ctx context.Context) error {
 	wt, err := gs.repository.Worktree()
 	if err != nil {
@@ -50,10 +160,54 @@ func (gs *GitSource) pull(ctx context.Context) error {
 }
 
 func (gs *GitSource) GetDirectory() string {
+	if gs.snapshotFs != nil {
+		return filepath.Join(gs.snapshotFs.Root(), file.LatestLinkName)
+	}
 	return gs.config.Directory
 }
 
 func (gs *GitSource) Refresh(ctx context.Context) ([]string, error) {
+	if gs.replayStepPending {
+		// The changes from the checked out commit haven't been applied successfully yet
+		logger := gs.getLogCtx(zerolog.Ctx(ctx))
+		logger.Info().Msg("replaying unacknowledged Git commit again")
+		return gs.replayStepFiles, nil
+	}
+	gs.replayRemaining = false
+
+	files, err := gs.refresh(ctx)
+	if errors.Is(err, errBrokenRepository) {
+		files, err = gs.recoverRepository(ctx, err)
+	}
+	if err == nil && gs.snapshotFs != nil {
+		files, err = gs.publishChanges(files, gs.getLogCtx(zerolog.Ctx(ctx)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The kinds of the changes are kept until the changes are reported
+	gs.changes = gs.pendingChanges.Describe(files)
+	gs.pendingChanges = nil
+	if gs.config.Replay {
+		head, err := gs.repository.Head()
+		if err != nil {
+			return nil, err
+		}
+		gs.replayStepPending = true
+		gs.replayStepHash = head.Hash()
+		gs.replayStepFiles = files
+	}
+	return files, nil
+}
+
+// Changes tells which of the files were added, modified, deleted, or renamed on the latest refresh.
+// Renames are detected by comparing the contents of the removed and the added files.
+func (gs *GitSource) Changes() changeset.ChangeSet {
+	return gs.changes
+}
+
+func (gs *GitSource) refresh(ctx context.Context) ([]string, error) {
 	// Repository not set up yet -> initialize it
 	if gs.repository == nil {
 		return gs.refreshInit(ctx)
@@ -73,6 +227,9 @@ func (gs *GitSource) refreshInit(ctx context.Context) ([]string, error) {
 	// This is usually in situations where konvahti is rebooted.
 	gs.repository, err = git.PlainOpen(gs.config.Directory)
 	if err == nil {
+		if gs.tagMatcher != nil {
+			gs.tag = gs.localTagAtHead()
+		}
 		logger := gs.getLogCtx(zerolog.Ctx(ctx))
 		logger.Info().Msg("refreshing files from a Git repo found on file system")
 		return gs.refreshExisting(ctx, logger)
@@ -82,15 +239,28 @@ func (gs *GitSource) refreshInit(ctx context.Context) ([]string, error) {
 	// Since we don't have any previous commit to compare changes to,
 	// we can just list the files found in the repository.
 	if err == git.ErrRepositoryNotExists {
+		if gs.resolvesTarget() {
+			gs.repository, err = gs.initRepository()
+			if err != nil {
+				return nil, err
+			}
+			logger := gs.getLogCtx(zerolog.Ctx(ctx))
+			logger.Info().Msg("fetching files to a new Git repo")
+			return gs.refreshTarget(ctx, nil, logger)
+		}
 		gs.repository, err = gs.clone(ctx)
 	}
 	if err != nil {
+		if gs.repository == nil && err != git.ErrRepositoryNotExists {
+			// The repository exists, but it can't be opened
+			return nil, brokenRepositoryError(err)
+		}
 		return nil, err
 	}
 
 	logger := gs.getLogCtx(zerolog.Ctx(ctx))
 	logger.Info().Msg("providing list of files cloned from Git")
-	return gitListCurrentFiles(gs.repository)
+	return gs.listChangedFiles(ctx, nil, logger)
 }
 
 func gitListCurrentFiles(repo *git.Repository) (files []string, err error) {
@@ -130,10 +300,61 @@ func (gs *GitSource) refreshExisting(
 	ctx context.Context,
 	logger zerolog.Logger,
 ) ([]string, error) {
-	prevHead, err := gs.repository.Head()
+	prevHead, err := gs.checkHead()
 	if err != nil {
 		return nil, err
 	}
+	if prevHead == nil {
+		// Nothing has been checked out to the repository yet
+		return gs.refreshTarget(ctx, nil, logger)
+	}
+	prevFound, err := gs.hasCommit(prevHead.Hash())
+	if err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+
+	// Locally modified files would block the updates, so they are restored first.
+	// They are reported as changed files once the refresh succeeds.
+	if prevFound && gs.canRestoreFiles() {
+		restored, err := gs.restoreModifiedFiles(prevHead, logger)
+		if err != nil {
+			return nil, err
+		}
+		gs.restoredFiles = mergeFiles(gs.restoredFiles, restored)
+	}
+
+	if gs.config.Replay {
+		// The checked out commit might not have been acknowledged before a restart
+		if prevHead, err = gs.replayBase(); err != nil {
+			return nil, err
+		}
+		prevFound = false
+		if prevHead != nil {
+			if prevFound, err = gs.hasCommit(prevHead.Hash()); err != nil {
+				return nil, brokenRepositoryError(err)
+			}
+		}
+	}
+
+	files, err := gs.updateExisting(ctx, prevHead, prevFound, logger)
+	if err != nil {
+		return nil, err
+	}
+	files = mergeFiles(gs.restoredFiles, files)
+	gs.restoredFiles = nil
+	return files, nil
+}
+
+func (gs *GitSource) updateExisting(
+	ctx context.Context,
+	prevHead *plumbing.Reference,
+	prevFound bool,
+	logger zerolog.Logger,
+) ([]string, error) {
+	// Pulling requires the previous commit, so the branch is checked out without it
+	if gs.resolvesTarget() || !prevFound {
+		return gs.refreshTarget(ctx, prevHead, logger)
+	}
 
 	logger.Debug().Msg("pulling latest changes from git remote")
 	if err := gs.pull(ctx); err != nil {
@@ -144,46 +365,477 @@ func (gs *GitSource) refreshExisting(
 		return nil, err
 	}
 
-	return gitListChangedFiles(gs.repository, prevHead, logger)
+	return gs.listChangedFiles(ctx, prevHead, logger)
 }
 
-func gitListChangedFiles(
+// refreshTarget checks out the commit resolved from the tags, the pointer, or the branch.
+// With tags and pointers, the commit can move both forward and backward, and the changes
+// are listed against the previously checked out commit.
+func (gs *GitSource) refreshTarget(
+	ctx context.Context,
+	prevHead *plumbing.Reference,
+	logger zerolog.Logger,
+) ([]string, error) {
+	target, err := gs.resolveTarget(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	if prevHead != nil && target.hash == prevHead.Hash() {
+		logger.Debug().Msg("no changes found")
+		return nil, nil
+	}
+
+	prevFound := false
+	if prevHead != nil {
+		if prevFound, err = gs.hasCommit(prevHead.Hash()); err != nil {
+			return nil, err
+		}
+	}
+	if target.branch != "" && prevFound {
+		// Same as with pulling, only fast-forward updates are accepted unless the branch is reset
+		isFastForward, err := isAncestor(gs.repository, prevHead.Hash(), target.hash)
+		if err == plumbing.ErrObjectNotFound && gs.config.UpdateStrategy == UpdateStrategyReset {
+			// The history between the commits is beyond the fetched history
+			isFastForward, err = false, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isFastForward {
+			if gs.config.UpdateStrategy != UpdateStrategyReset {
+				return nil, git.ErrNonFastForwardUpdate
+			}
+			logger.Warn().
+				Str("gitHashNext", target.hash.String()).
+				Msg("branch history rewritten, resetting to remote branch")
+		}
+	}
+
+	if gs.config.Replay && prevFound {
+		next, err := gs.nextReplayCommit(prevHead.Hash(), target.hash)
+		if err != nil {
+			return nil, err
+		}
+		if next != target.hash {
+			logger.Debug().
+				Str("gitHashTarget", target.hash.String()).
+				Msg("replaying commits one at a time")
+			target.hash = next
+			gs.replayRemaining = true
+		}
+	}
+
+	if gs.verifier != nil {
+		var prevHash plumbing.Hash
+		if prevHead != nil {
+			prevHash = prevHead.Hash()
+		}
+		if err := gs.verifier.verify(gs.repository, prevHash, target.hash); err != nil {
+			// The previous checkout is kept in place, so the actions are not run for unverified commits
+			logger.Error().
+				Err(err).
+				Str("gitHashNext", target.hash.String()).
+				Msg("refusing to check out commit with unverified signature")
+			return nil, err
+		}
+	}
+
+	if gs.lfsClient != nil {
+		if err := gs.fetchLFSObjects(ctx, target.hash, logger); err != nil {
+			return nil, fmt.Errorf("failed to fetch Git LFS objects: %w", err)
+		}
+	}
+
+	logger.Info().
+		Str("gitHashNext", target.hash.String()).
+		Msg("checking out commit")
+	if gs.isSparse() {
+		err = gs.checkoutSparseTarget(target, prevHead)
+	} else if target.branch != "" {
+		err = checkoutBranch(gs.repository, target.branch, target.hash)
+	} else {
+		err = checkoutDetached(gs.repository, target.hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if gs.lfsClient != nil {
+		if err := gs.checkoutLFSObjects(target.hash); err != nil {
+			// HEAD is moved back, so that the checkout is retried on the next refresh
+			if resetErr := resetHead(gs.repository, prevHead); resetErr != nil {
+				logger.Error().Err(resetErr).Msg("failed to reset HEAD after failed checkout")
+			}
+			return nil, err
+		}
+	}
+	if gs.tagMatcher != nil {
+		gs.previousTag, gs.tag = gs.tag, target.tag
+	}
+
+	return gs.listChangedFiles(ctx, prevHead, logger)
+}
+
+// checkoutSparseTarget checks out the included paths of the target.
+// The working directory is fully replaced on the first checkout after startup,
+// because the files outside the included paths may have been checked out earlier.
+func (gs *GitSource) checkoutSparseTarget(target checkoutTarget, prevHead *plumbing.Reference) error {
+	if !gs.sparseSynced {
+		prevHead = nil
+	}
+	if err := gs.checkoutSparse(target, prevHead); err != nil {
+		// Some of the files might already be from the target, so they are all replaced on the next checkout
+		gs.sparseSynced = false
+		return err
+	}
+	gs.sparseSynced = true
+	return nil
+}
+
+type checkoutTarget struct {
+	hash plumbing.Hash
+	// Set when the target is the latest commit of a branch
+	branch plumbing.ReferenceName
+	// Set when the target is a tag
+	tag string
+}
+
+func (gs *GitSource) resolveTarget(ctx context.Context, logger zerolog.Logger) (checkoutTarget, error) {
+	if gs.tagMatcher != nil {
+		logger.Debug().Msg("looking up latest matching tag from git remote")
+		tagRef, err := gs.latestRemoteTag(ctx)
+		if err != nil {
+			return checkoutTarget{}, err
+		}
+		hash, err := gs.fetchRef(ctx, tagRef.Name(), tagRef.Name())
+		return checkoutTarget{hash: hash, tag: tagRef.Name().Short()}, err
+	}
+
+	if gs.config.Pointer.isSet() {
+		logger.Debug().Msg("resolving commit from git pointer")
+		hash, err := gs.resolvePointer(ctx)
+		return checkoutTarget{hash: hash}, err
+	}
+
+	logger.Debug().Msg("fetching latest changes from git remote")
+	branchRef := plumbing.NewBranchReferenceName(gs.config.Branch)
+	hash, err := gs.fetchRef(ctx, branchRef, gs.remoteBranchRef())
+	return checkoutTarget{hash: hash, branch: branchRef}, err
+}
+
+func (gs *GitSource) remoteBranchRef() plumbing.ReferenceName {
+	return plumbing.NewRemoteReferenceName(git.DefaultRemoteName, gs.config.Branch)
+}
+
+// fetchRef fetches the remote reference to the local reference,
+// and returns the commit it points to.
+func (gs *GitSource) fetchRef(
+	ctx context.Context,
+	remoteName plumbing.ReferenceName,
+	localName plumbing.ReferenceName,
+) (plumbing.Hash, error) {
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("+%s:%s", remoteName, localName))
+	err := gs.repository.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []gitconfig.RefSpec{refSpec},
+		Depth:      gs.cloneOptions.Depth,
+		Auth:       gs.cloneOptions.Auth,
+		Tags:       git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, err
+	}
+	ref, err := gs.repository.Reference(localName, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return resolveCommitHash(gs.repository, ref.Hash())
+}
+
+func checkoutDetached(repo *git.Repository, hash plumbing.Hash) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+}
+
+// checkoutBranch points the branch to the commit, and checks out the branch.
+// resetHead points HEAD back to the previous reference without touching the working directory.
+// Without a previous reference, the branch that HEAD points to (or a detached HEAD) is removed,
+// so that the next checkout starts from scratch.
+func resetHead(repo *git.Repository, prevHead *plumbing.Reference) error {
+	if prevHead == nil {
+		head, err := repo.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return err
+		}
+		if head.Type() == plumbing.SymbolicReference {
+			return repo.Storer.RemoveReference(head.Target())
+		}
+		return repo.Storer.RemoveReference(plumbing.HEAD)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(prevHead.Name(), prevHead.Hash())); err != nil {
+		return err
+	}
+	if prevHead.Name() == plumbing.HEAD {
+		return nil
+	}
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, prevHead.Name()))
+}
+
+func checkoutBranch(repo *git.Repository, branchRef plumbing.ReferenceName, hash plumbing.Hash) error {
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, hash)); err != nil {
+		return err
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+}
+
+// isAncestor checks whether the first commit is an ancestor of the second commit.
+func isAncestor(repo *git.Repository, first plumbing.Hash, second plumbing.Hash) (bool, error) {
+	firstCommit, err := repo.CommitObject(first)
+	if err != nil {
+		return false, err
+	}
+	secondCommit, err := repo.CommitObject(second)
+	if err != nil {
+		return false, err
+	}
+	return firstCommit.IsAncestor(secondCommit)
+}
+
+// resolveCommitHash resolves the commit that the object points to.
+// Annotated tags point to tag objects instead of commits.
+func resolveCommitHash(repo *git.Repository, hash plumbing.Hash) (plumbing.Hash, error) {
+	tag, err := repo.TagObject(hash)
+	if err == plumbing.ErrObjectNotFound {
+		return hash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return commit.Hash, nil
+}
+
+// EnvVars exposes the checked out commit and the previously checked out commit to the actions.
+// The deployed tag and the previously deployed tag are included when tags are tracked instead of a branch.
+func (gs *GitSource) EnvVars() envvars.EnvVars {
+	var ev envvars.EnvVars
+	if commit, branch := gs.headCommit(); commit != nil {
+		var previousHash string
+		if !gs.previousHash.IsZero() {
+			previousHash = gs.previousHash.String()
+		}
+		ev = ev.
+			Add("KONVAHTI_GIT_COMMIT", commit.Hash.String()).
+			Add("KONVAHTI_GIT_PREVIOUS_COMMIT", previousHash).
+			Add("KONVAHTI_GIT_BRANCH", branch).
+			Add("KONVAHTI_GIT_AUTHOR", fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email)).
+			Add("KONVAHTI_GIT_TIMESTAMP", commit.Committer.When.UTC().Format(time.RFC3339)).
+			Add("KONVAHTI_GIT_SUBJECT", commitSubject(commit.Message))
+	}
+	if gs.tagMatcher != nil {
+		ev = ev.
+			Add("KONVAHTI_GIT_TAG", gs.tag).
+			Add("KONVAHTI_GIT_PREVIOUS_TAG", gs.previousTag)
+	}
+	return ev
+}
+
+// headCommit returns the checked out commit, and the name of the branch when a branch is checked out.
+func (gs *GitSource) headCommit() (*object.Commit, string) {
+	if gs.repository == nil {
+		return nil, ""
+	}
+	head, err := gs.repository.Head()
+	if err != nil {
+		return nil, ""
+	}
+	commit, err := gs.repository.CommitObject(head.Hash())
+	if err != nil {
+		return nil, ""
+	}
+	var branch string
+	if head.Name().IsBranch() {
+		branch = head.Name().Short()
+	}
+	return commit, branch
+}
+
+func commitSubject(message string) string {
+	return strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+}
+
+// listChangedFiles lists the files changed since the previous commit,
+// or all of the files when there's no previous commit. When the previous commit is no longer
+// found from the repository, the files are compared to the manifest recorded on the previous refresh.
+// When submodules are enabled, they are updated, and the files changed in them are included.
+// When only some of the paths are checked out, the files outside them are left out.
+func (gs *GitSource) listChangedFiles(
+	ctx context.Context,
+	prevHead *plumbing.Reference,
+	logger zerolog.Logger,
+) ([]string, error) {
+	curHead, err := gs.repository.Head()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := commitManifest(gs.repository, curHead.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	var prevCommit *object.Commit
+	if prevHead != nil {
+		prevCommit, err = gs.repository.CommitObject(prevHead.Hash())
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+	}
+
+	var files []string
+	var changes changeset.ChangeSet
+	switch {
+	case prevHead == nil:
+		files, err = gitListCurrentFiles(gs.repository)
+		changes = changeset.FromPaths(changeset.Added, files)
+	case prevCommit == nil:
+		logger.Warn().
+			Str("gitHashPrevious", prevHead.Hash().String()).
+			Msg("previous commit not found, comparing files to the recorded manifest")
+		changes, err = gs.listManifestChanges(manifest)
+		files = changes.Paths()
+	default:
+		changes, err = gitListChanges(ctx, gs.repository, prevHead, logger)
+		files = changes.Paths()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gs.writeManifest(manifest); err != nil {
+		return nil, fmt.Errorf("failed to record file manifest: %w", err)
+	}
+	gs.pendingChanges = append(gs.pendingChanges, changes...)
+	if prevHead != nil {
+		gs.previousHash = prevHead.Hash()
+	} else {
+		gs.previousHash = plumbing.ZeroHash
+	}
+	if !gs.config.Submodules {
+		return gs.pathFilter.filter(files), nil
+	}
+
+	logger.Debug().Msg("updating git submodules")
+	if err := gs.updateSubmodules(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update submodules: %w", err)
+	}
+	curCommit, err := gs.repository.CommitObject(curHead.Hash())
+	if err != nil {
+		return nil, err
+	}
+	submoduleFiles, err := submoduleChangedFiles(gs.repository, prevCommit, curCommit)
+	if err != nil {
+		return nil, err
+	}
+	// The files in the submodules are only compared by commit, so they are listed as modified
+	submoduleKind := changeset.Modified
+	if prevHead == nil {
+		submoduleKind = changeset.Added
+	}
+	gs.pendingChanges = append(gs.pendingChanges, changeset.FromPaths(submoduleKind, submoduleFiles)...)
+	return append(files, submoduleFiles...), nil
+}
+
+// listManifestChanges lists the changes since the recorded manifest.
+// When no manifest has been recorded, all of the files are listed as added.
+func (gs *GitSource) listManifestChanges(manifest fileManifest) (changeset.ChangeSet, error) {
+	prevManifest, err := gs.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	if prevManifest == nil {
+		files, err := gitListCurrentFiles(gs.repository)
+		if err != nil {
+			return nil, err
+		}
+		return changeset.FromPaths(changeset.Added, files), nil
+	}
+	return manifest.changes(prevManifest), nil
+}
+
+// gitListChanges lists the changes between the previously checked out commit and the current commit.
+// Renamed files are detected from similar contents.
+func gitListChanges(
+	ctx context.Context,
 	repo *git.Repository,
 	ref *plumbing.Reference,
 	logger zerolog.Logger,
-) (files []string, err error) {
+) (changeset.ChangeSet, error) {
 	prevCommit, err := repo.CommitObject(ref.Hash())
 	if err != nil {
-		return
+		return nil, err
 	}
 	prevTree, err := prevCommit.Tree()
 	if err != nil {
-		return
+		return nil, err
 	}
 	curHead, err := repo.Head()
 	if err != nil {
-		return
+		return nil, err
 	}
 	curCommit, err := repo.CommitObject(curHead.Hash())
 	if err != nil {
-		return
+		return nil, err
 	}
 	curTree, err := curCommit.Tree()
 	if err != nil {
-		return
-	}
-	patch, err := curTree.Patch(prevTree)
-	if err != nil {
-		return
+		return nil, err
 	}
 
 	logger.Debug().
 		Str("gitHashNext", curHead.Hash().String()).
 		Msg("getting list of changed files")
-	for _, fileStat := range patch.Stats() {
-		files = append(files, fileStat.Name)
+	treeChanges, err := object.DiffTreeWithOptions(ctx, prevTree, curTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, err
 	}
-	return
+
+	changes := make(changeset.ChangeSet, 0, len(treeChanges))
+	for _, treeChange := range treeChanges {
+		// The files changed in the submodules are listed separately
+		if treeChange.From.TreeEntry.Mode == filemode.Submodule || treeChange.To.TreeEntry.Mode == filemode.Submodule {
+			continue
+		}
+		action, err := treeChange.Action()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case action == merkletrie.Insert:
+			changes = append(changes, changeset.Change{Kind: changeset.Added, Path: treeChange.To.Name})
+		case action == merkletrie.Delete:
+			changes = append(changes, changeset.Change{Kind: changeset.Deleted, Path: treeChange.From.Name})
+		case treeChange.From.Name != treeChange.To.Name:
+			changes = append(changes, changeset.Change{
+				Kind:    changeset.Renamed,
+				Path:    treeChange.To.Name,
+				OldPath: treeChange.From.Name,
+			})
+		default:
+			changes = append(changes, changeset.Change{Kind: changeset.Modified, Path: treeChange.To.Name})
+		}
+	}
+	return changes, nil
 }
 
 func (gs *GitSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
@@ -198,6 +850,7 @@ func (gs *GitSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
 		Str("stage", "refresh").
 		Str("gitUrl", gs.cloneOptions.URL).
 		Str("gitBranch", gs.config.Branch).
+		Str("gitTag", gs.tag).
 		Str("gitHash", currentCommitHash).
 		Logger()
 }
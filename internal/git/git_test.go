@@ -0,0 +1,459 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+// requireGit skips the test when Git isn't installed.
+// Git is needed for serving local repositories to go-git.
+func requireGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found from PATH")
+	}
+}
+
+func TestTagMatcher(t *testing.T) {
+	a := assert.New(t)
+	tags := []string{"v1.0.0", "v1.10.0", "v1.9.0", "v2.0.0", "v1.11.0-rc.1", "release-b", "release-a", "latest"}
+
+	matcher, err := newTagMatcher(">=1.0.0 <2.0.0")
+	if a.NoError(err) {
+		tag, ok := matcher.highest(tags)
+		a.True(ok)
+		a.Equal("v1.10.0", tag)
+	}
+
+	matcher, err = newTagMatcher("release-*")
+	if a.NoError(err) {
+		tag, ok := matcher.highest(tags)
+		a.True(ok)
+		a.Equal("release-b", tag)
+	}
+
+	matcher, err = newTagMatcher("v3.*")
+	if a.NoError(err) {
+		_, ok := matcher.highest(tags)
+		a.False(ok)
+	}
+}
+
+func TestSSHAuthValidation(t *testing.T) {
+	a := assert.New(t)
+	config := Config{
+		URL:       "ssh://git@example.org/repo.git",
+		Branch:    "main",
+		Directory: t.TempDir(),
+	}
+	a.NoError(config.Validate())
+
+	config.SSHAuth = GitSSHAuth{Username: "git", KeyPath: "/tmp/id_ed25519"}
+	a.NoError(config.Validate())
+
+	config.SSHAuth.Key = "inline key"
+	a.Error(config.Validate())
+
+	config.SSHAuth = GitSSHAuth{UseAgent: true}
+	a.Error(config.Validate())
+}
+
+func TestTagRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	targetDir := filepath.Join(t.TempDir(), "repo")
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	if _, err := upstream.CreateTag("v1.0.0", first, &git.CreateTagOptions{
+		Message: "Release 1.0.0",
+		Tagger:  &object.Signature{Name: "Konvahti", Email: "konvahti@example.org", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	if _, err := upstream.CreateTag("v2.0.0", second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Tag:       ">=1.0.0 <2.0.0",
+		Directory: targetDir,
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	a.Subset(source.EnvVars(), []string{"KONVAHTI_GIT_TAG=v1.0.0", "KONVAHTI_GIT_PREVIOUS_TAG="})
+
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{"db.yaml": "host: localhost"})
+	if _, err := upstream.CreateTag("v1.1.0", third, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes are computed between the deployed tags, so the changes from the commits in between are included
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml"}, changed)
+	a.Subset(source.EnvVars(), []string{"KONVAHTI_GIT_TAG=v1.1.0", "KONVAHTI_GIT_PREVIOUS_TAG=v1.0.0"})
+	data, err := os.ReadFile(filepath.Join(targetDir, "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+
+	// A new source instance finds the deployed tag from the repository on the file system
+	var restarted GitSource
+	if err := restarted.Setup(source.config); !a.NoError(err) {
+		return
+	}
+	changed, err = restarted.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+	a.Subset(restarted.EnvVars(), []string{"KONVAHTI_GIT_TAG=v1.1.0", "KONVAHTI_GIT_PREVIOUS_TAG="})
+}
+
+func TestPointerRefRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointerRef := plumbing.ReferenceName("refs/deploy/prod")
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2", "db.yaml": "host: localhost"})
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 3"})
+	setRef := func(hash plumbing.Hash) {
+		if err := upstream.Storer.SetReference(plumbing.NewHashReference(pointerRef, hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setRef(second)
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Pointer:   GitPointer{Ref: pointerRef.String()},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml"}, changed)
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	// Moving the pointer backward is a change as well
+	setRef(first)
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml"}, changed)
+	_, err = os.Stat(filepath.Join(source.GetDirectory(), "db.yaml"))
+	a.True(os.IsNotExist(err))
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+}
+
+func TestPointerFileRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	pointerDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+
+	pointerRepo, err := git.PlainInit(pointerDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pointerRepo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/releases")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, pointerRepo, pointerDir, map[string]string{"prod.sha": first.String() + "\n"})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:    upstreamDir,
+		Branch: "main",
+		Pointer: GitPointer{
+			URL:    pointerDir,
+			Branch: "releases",
+			File:   "prod.sha",
+		},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+
+	commitFiles(t, pointerRepo, pointerDir, map[string]string{"prod.sha": second.String() + "\n"})
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	data, err = os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	commitFiles(t, pointerRepo, pointerDir, map[string]string{"prod.sha": "not a hash\n"})
+	_, err = source.Refresh(ctx)
+	a.Error(err)
+}
+
+func TestResetStrategyRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2", "db.yaml": "host: localhost"})
+
+	config := Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}
+	var fastForwardSource GitSource
+	if err := fastForwardSource.Setup(config); !a.NoError(err) {
+		return
+	}
+	if _, err := fastForwardSource.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	config.Directory = filepath.Join(t.TempDir(), "repo")
+	config.UpdateStrategy = UpdateStrategyReset
+	var resetSource GitSource
+	if err := resetSource.Setup(config); !a.NoError(err) {
+		return
+	}
+	if _, err := resetSource.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+
+	// Force-push a commit that replaces the second commit
+	wt, err := upstream.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: first, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 3", "web.yaml": "port: 8080"})
+
+	_, err = fastForwardSource.Refresh(ctx)
+	a.ErrorIs(err, git.ErrNonFastForwardUpdate)
+
+	changed, err := resetSource.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml", "web.yaml"}, changed)
+	data, err := os.ReadFile(filepath.Join(resetSource.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 3", string(data))
+	}
+	a.NoFileExists(filepath.Join(resetSource.GetDirectory(), "db.yaml"))
+
+	changed, err = resetSource.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+}
+
+func TestCommitEnvVars(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	a.Empty(source.EnvVars())
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	envVars := source.EnvVars()
+	a.Subset(envVars, []string{
+		"KONVAHTI_GIT_COMMIT=" + first.String(),
+		"KONVAHTI_GIT_PREVIOUS_COMMIT=",
+		"KONVAHTI_GIT_BRANCH=main",
+		"KONVAHTI_GIT_AUTHOR=Konvahti <konvahti@example.org>",
+		"KONVAHTI_GIT_SUBJECT=update",
+	})
+	timestamp, _ := envVars.Lookup("KONVAHTI_GIT_TIMESTAMP")
+	_, err = time.Parse(time.RFC3339, timestamp)
+	a.NoError(err)
+
+	wt, err := upstream.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(upstreamDir, "app.yaml"), []byte("version: 2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("app.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := wt.Commit("Release 2\n\nWith a longer description", &git.CommitOptions{
+		Author: &object.Signature{Name: "Release Bot", Email: "bot@example.org", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	a.Subset(source.EnvVars(), []string{
+		"KONVAHTI_GIT_COMMIT=" + second.String(),
+		"KONVAHTI_GIT_PREVIOUS_COMMIT=" + first.String(),
+		"KONVAHTI_GIT_BRANCH=main",
+		"KONVAHTI_GIT_AUTHOR=Release Bot <bot@example.org>",
+		"KONVAHTI_GIT_SUBJECT=Release 2",
+	})
+}
+
+func TestChangesRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	backendConfig := "name: backend\nreplicas: 3\nimage: registry.example.org/backend:1.2.3\n"
+	commitFiles(t, upstream, upstreamDir, map[string]string{
+		"app.yaml":     "version: 1",
+		"db.yaml":      "host: localhost",
+		"backend.yaml": backendConfig,
+	})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Added, Path: "app.yaml"},
+		{Kind: changeset.Added, Path: "db.yaml"},
+		{Kind: changeset.Added, Path: "backend.yaml"},
+	}, source.Changes())
+
+	commitFiles(t, upstream, upstreamDir, map[string]string{
+		"app.yaml":     "version: 2",
+		"db.yaml":      "",
+		"backend.yaml": "",
+		"api.yaml":     backendConfig,
+		"web.yaml":     "port: 8080",
+	})
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml", "backend.yaml", "api.yaml", "web.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "db.yaml"},
+		{Kind: changeset.Renamed, Path: "api.yaml", OldPath: "backend.yaml"},
+		{Kind: changeset.Added, Path: "web.yaml"},
+	}, source.Changes())
+
+	if _, err := source.Refresh(ctx); a.NoError(err) {
+		a.Empty(source.Changes())
+	}
+}
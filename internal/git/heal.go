@@ -0,0 +1,153 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog"
+)
+
+const brokenDirectorySuffix = ".broken"
+
+// errBrokenRepository is returned when the local repository can't be used anymore,
+// and it needs to be cloned again.
+var errBrokenRepository = errors.New("local Git repository is broken")
+
+func brokenRepositoryError(err error) error {
+	return fmt.Errorf("%w: %s", errBrokenRepository, err)
+}
+
+// checkHead resolves the currently checked out commit, and checks that the repository can be used.
+// Nil is returned when nothing has been checked out yet. The commit itself might be missing
+// when it's beyond the fetched history, which is not considered to be broken.
+func (gs *GitSource) checkHead() (*plumbing.Reference, error) {
+	if _, err := gs.repository.Remote(git.DefaultRemoteName); err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+	head, err := gs.repository.Head()
+	if gs.resolvesTarget() && err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+	commit, err := gs.repository.CommitObject(head.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		return head, nil
+	}
+	if err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+	if _, err := commit.Tree(); err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+	return head, nil
+}
+
+// recoverRepository moves the broken repository aside, and clones it again.
+// All of the files are listed as changed files, because the previous state isn't known.
+// Only the latest broken repository is kept for investigating the issue.
+func (gs *GitSource) recoverRepository(ctx context.Context, cause error) ([]string, error) {
+	brokenDirectory := gs.config.Directory + brokenDirectorySuffix
+	logger := gs.getLogCtx(zerolog.Ctx(ctx))
+	logger.Warn().
+		Err(cause).
+		Str("brokenDirectory", brokenDirectory).
+		Msg("moving broken Git repository aside and cloning it again")
+
+	gs.repository = nil
+	gs.sparseSynced = false
+	gs.restoredFiles = nil
+	if err := os.RemoveAll(brokenDirectory); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(gs.config.Directory, brokenDirectory); err != nil {
+		return nil, fmt.Errorf("failed to move broken Git repository aside: %w", err)
+	}
+	return gs.refreshInit(ctx)
+}
+
+// canRestoreFiles returns true when the working directory is expected to match the checked out commit.
+// This is not the case when only some of the paths are checked out, or when LFS objects are checked out.
+func (gs *GitSource) canRestoreFiles() bool {
+	return !gs.isSparse() && gs.lfsClient == nil
+}
+
+// restoreModifiedFiles restores the files modified in the working directory to the checked out versions.
+// Files that are not tracked in the repository are kept. The restored files are returned.
+func (gs *GitSource) restoreModifiedFiles(head *plumbing.Reference, logger zerolog.Logger) ([]string, error) {
+	wt, err := gs.repository.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, brokenRepositoryError(err)
+	}
+
+	var files []string
+	for name, fileStatus := range status {
+		if fileStatus.Worktree == git.Untracked {
+			continue
+		}
+		if fileStatus.Worktree != git.Unmodified || fileStatus.Staging != git.Unmodified {
+			files = append(files, name)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+
+	logger.Warn().
+		Strs("files", files).
+		Msg("restoring files modified in the local Git repository")
+
+	// Hard reset would remove the untracked files as well, so the files are restored one by one
+	if err := wt.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset}); err != nil {
+		return nil, err
+	}
+	commit, err := gs.repository.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		entry, err := tree.FindEntry(name)
+		if err == object.ErrEntryNotFound {
+			// Files added only to the index are kept as untracked files
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := gs.writeWorkingFile(tree, name, *entry); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// mergeFiles combines the file lists without duplicates.
+func mergeFiles(files []string, moreFiles []string) []string {
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file] = true
+	}
+	for _, file := range moreFiles {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files
+}
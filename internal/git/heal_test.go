@@ -0,0 +1,124 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokenRepositoryRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1", "db.yaml": "host: localhost"})
+
+	config := Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}
+	var source GitSource
+	if err := source.Setup(config); !a.NoError(err) {
+		return
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+
+	// Simulate a clone interrupted before the branch was written
+	if err := source.repository.Storer.RemoveReference("refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	var restarted GitSource
+	if err := restarted.Setup(config); !a.NoError(err) {
+		return
+	}
+	changed, err := restarted.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml"}, changed)
+	a.DirExists(config.Directory + brokenDirectorySuffix)
+	a.FileExists(filepath.Join(config.Directory, "app.yaml"))
+
+	// Repositories that can't be opened are cloned again as well
+	if err := os.WriteFile(filepath.Join(config.Directory, ".git", "config"), []byte("[broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restarted = GitSource{}
+	if err := restarted.Setup(config); !a.NoError(err) {
+		return
+	}
+	changed, err = restarted.Refresh(ctx)
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"app.yaml", "db.yaml"}, changed)
+	}
+}
+
+func TestModifiedFilesRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1", "db.yaml": "host: localhost"})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+
+	appFile := filepath.Join(source.GetDirectory(), "app.yaml")
+	if err := os.WriteFile(appFile, []byte("version: edited"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(source.GetDirectory(), "db.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The modified files are restored and reported, and they don't block the pull
+	commitFiles(t, upstream, upstreamDir, map[string]string{"web.yaml": "port: 8080"})
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "db.yaml", "web.yaml"}, changed)
+	data, err := os.ReadFile(appFile)
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+	a.FileExists(filepath.Join(source.GetDirectory(), "db.yaml"))
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+}
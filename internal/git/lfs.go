@@ -0,0 +1,370 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// Pointer files are always smaller than this
+	lfsPointerMaxSize = 1024
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+var (
+	lfsOIDRegex     = regexp.MustCompile("^[0-9a-f]{64}$")
+	scpLikeURLRegex = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+)
+
+type lfsPointer struct {
+	oid  string
+	size int64
+}
+
+// parseLFSPointer parses the Git LFS pointer file contents.
+// False is returned when the contents are not a pointer.
+func parseLFSPointer(data []byte) (lfsPointer, bool) {
+	var pointer lfsPointer
+	if len(data) > lfsPointerMaxSize || !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return pointer, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return pointer, false
+		}
+		switch fields[0] {
+		case "oid":
+			pointer.oid = strings.TrimPrefix(fields[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return pointer, false
+			}
+			pointer.size = size
+		}
+	}
+	return pointer, lfsOIDRegex.MatchString(pointer.oid) && pointer.size >= 0
+}
+
+// lfsEndpoint returns the LFS server URL for the repository URL the same way as Git LFS does.
+// SSH URLs are mapped to HTTPS URLs on the same host.
+func lfsEndpoint(repoURL string) (string, error) {
+	if match := scpLikeURLRegex.FindStringSubmatch(repoURL); match != nil && !strings.Contains(repoURL, "://") {
+		repoURL = fmt.Sprintf("https://%s/%s", match[1], strings.TrimPrefix(match[2], "/"))
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ssh", "git+ssh":
+		u.Scheme = "https"
+		u.User = nil
+		u.Host = u.Hostname()
+	default:
+		return "", fmt.Errorf("can't determine Git LFS URL for %s", repoURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(u.Path, ".git") {
+		u.Path += ".git"
+	}
+	u.Path += "/info/lfs"
+	return u.String(), nil
+}
+
+// lfsClient downloads objects using the Git LFS batch API.
+type lfsClient struct {
+	client   *http.Client
+	endpoint string
+	auth     httpclient.AuthConfig
+}
+
+func newLFSClient(config *Config, client *http.Client) (*lfsClient, error) {
+	endpoint := config.LFS.URL
+	if endpoint == "" {
+		var err error
+		if endpoint, err = lfsEndpoint(config.URL); err != nil {
+			return nil, err
+		}
+	}
+	return &lfsClient{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		auth: httpclient.AuthConfig{
+			Username: config.HTTPAuth.Username,
+			Password: config.HTTPAuth.Password,
+			Token:    config.HTTPAuth.Token,
+		},
+	}, nil
+}
+
+type lfsBatchRequest struct {
+	Operation string           `json:"operation"`
+	Transfers []string         `json:"transfers"`
+	Objects   []lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchObject struct {
+	OID     string               `json:"oid"`
+	Size    int64                `json:"size"`
+	Actions map[string]lfsAction `json:"actions,omitempty"`
+	Error   *lfsError            `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// batch requests the download actions for the objects.
+func (c *lfsClient) batch(ctx context.Context, pointers []lfsPointer) ([]lfsBatchObject, error) {
+	request := lfsBatchRequest{Operation: "download", Transfers: []string{"basic"}}
+	for _, pointer := range pointers {
+		request.Objects = append(request.Objects, lfsBatchObject{OID: pointer.oid, Size: pointer.size})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	c.auth.Apply(req)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s from Git LFS batch API", res.Status)
+	}
+	var response lfsBatchResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse Git LFS batch response: %w", err)
+	}
+	return response.Objects, nil
+}
+
+// download downloads the object to the file.
+// The contents are verified against the object ID and size before the file is written.
+func (c *lfsClient) download(ctx context.Context, object lfsBatchObject, filename string) error {
+	action, ok := object.Actions["download"]
+	if !ok {
+		return fmt.Errorf("no download action for Git LFS object %s", object.OID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return err
+	}
+	if len(action.Header) == 0 {
+		c.auth.Apply(req)
+	}
+	for key, value := range action.Header {
+		req.Header.Set(key, value)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %s for Git LFS object %s", res.Status, object.OID)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), res.Body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size != object.Size || hex.EncodeToString(hash.Sum(nil)) != object.OID {
+		return fmt.Errorf("downloaded Git LFS object %s doesn't match its ID", object.OID)
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// fetchLFSObjects downloads the LFS objects of the commit to the object cache in the repository
+// metadata directory. Only the objects missing from the cache are downloaded.
+// The objects are fetched before the commit is checked out, so failed downloads leave
+// the previous checkout in place.
+func (gs *GitSource) fetchLFSObjects(ctx context.Context, hash plumbing.Hash, logger zerolog.Logger) error {
+	files, err := gs.lfsPointerFiles(hash)
+	if err != nil {
+		return err
+	}
+
+	var missing []lfsPointer
+	seen := make(map[string]bool)
+	for _, pointer := range files {
+		if seen[pointer.oid] {
+			continue
+		}
+		seen[pointer.oid] = true
+		if _, err := os.Stat(gs.lfsObjectPath(pointer.oid)); os.IsNotExist(err) {
+			missing = append(missing, pointer)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	logger.Debug().Msgf("downloading %d Git LFS objects", len(missing))
+	objects, err := gs.lfsClient.batch(ctx, missing)
+	if err != nil {
+		return err
+	}
+	fetched := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		if object.Error != nil {
+			return fmt.Errorf("failed to fetch Git LFS object %s: %s", object.OID, object.Error.Message)
+		}
+		if !seen[object.OID] {
+			return fmt.Errorf("unexpected Git LFS object %s in batch response", object.OID)
+		}
+		if err := gs.lfsClient.download(ctx, object, gs.lfsObjectPath(object.OID)); err != nil {
+			return err
+		}
+		fetched[object.OID] = true
+	}
+	// The server might leave out objects from the response
+	for _, pointer := range missing {
+		if !fetched[pointer.oid] {
+			return fmt.Errorf("batch response is missing Git LFS object %s", pointer.oid)
+		}
+	}
+	return nil
+}
+
+// checkoutLFSObjects replaces the LFS pointer files in the working directory with the cached objects.
+// The objects that are no longer used are removed from the cache.
+func (gs *GitSource) checkoutLFSObjects(hash plumbing.Hash) error {
+	files, err := gs.lfsPointerFiles(hash)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for name, pointer := range files {
+		used[pointer.oid] = true
+		if err := copyFile(gs.lfsObjectPath(pointer.oid), filepath.Join(gs.config.Directory, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("failed to check out Git LFS object for %s: %w", name, err)
+		}
+	}
+	return gs.pruneLFSObjects(used)
+}
+
+// lfsPointerFiles lists the files that are LFS pointers in the commit.
+func (gs *GitSource) lfsPointerFiles(hash plumbing.Hash) (map[string]lfsPointer, error) {
+	commit, err := gs.repository.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make(map[string]lfsPointer)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if file.Size > lfsPointerMaxSize || file.Mode == filemode.Symlink {
+			return nil
+		}
+		if !gs.pathFilter.includes(file.Name) {
+			return nil
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		if pointer, ok := parseLFSPointer([]byte(contents)); ok {
+			pointers[file.Name] = pointer
+		}
+		return nil
+	})
+	return pointers, err
+}
+
+// lfsObjectPath returns the path of the cached object using the same layout as Git LFS.
+func (gs *GitSource) lfsObjectPath(oid string) string {
+	return filepath.Join(gs.config.Directory, git.GitDirName, "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+func (gs *GitSource) pruneLFSObjects(used map[string]bool) error {
+	objectsDir := filepath.Join(gs.config.Directory, git.GitDirName, "lfs", "objects")
+	return filepath.WalkDir(objectsDir, func(path string, entry os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || entry.IsDir() || used[entry.Name()] {
+			return err
+		}
+		return os.Remove(path)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// The file permissions of the pointer file are kept
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
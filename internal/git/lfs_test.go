@@ -0,0 +1,211 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLFSServer serves the objects using the Git LFS batch API, and counts the downloads.
+type fakeLFSServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	objects   map[string]string
+	omitted   map[string]bool
+	downloads int
+}
+
+func newFakeLFSServer(t *testing.T) *fakeLFSServer {
+	s := &fakeLFSServer{objects: map[string]string{}, omitted: map[string]bool{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// pointer stores the contents as an LFS object, and returns the pointer file contents for it.
+func (s *fakeLFSServer) pointer(contents string) string {
+	oid := lfsOID(contents)
+	s.mutex.Lock()
+	s.objects[oid] = contents
+	s.mutex.Unlock()
+	return fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(contents))
+}
+
+// omit makes the batch API leave out the object from its responses.
+func (s *fakeLFSServer) omit(oid string, omitted bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.omitted[oid] = omitted
+}
+
+func lfsOID(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *fakeLFSServer) handle(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "konvahti" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.Method == http.MethodPost && r.URL.Path == "/repo.git/info/lfs/objects/batch" {
+		var request lfsBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var response lfsBatchResponse
+		for _, object := range request.Objects {
+			if s.omitted[object.OID] {
+				continue
+			}
+			if _, ok := s.objects[object.OID]; !ok {
+				object.Error = &lfsError{Code: http.StatusNotFound, Message: "object not found"}
+			} else {
+				object.Actions = map[string]lfsAction{"download": {Href: s.URL + "/objects/" + object.OID}}
+			}
+			response.Objects = append(response.Objects, object)
+		}
+		w.Header().Set("Content-Type", lfsMediaType)
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	contents, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/objects/")]
+	if r.Method != http.MethodGet || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.downloads++
+	_, _ = w.Write([]byte(contents))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	a := assert.New(t)
+	oid := strings.Repeat("ab", 32)
+
+	pointer, ok := parseLFSPointer([]byte(fmt.Sprintf("%s\noid sha256:%s\nsize 42\n", lfsPointerVersion, oid)))
+	a.True(ok)
+	a.Equal(lfsPointer{oid: oid, size: 42}, pointer)
+
+	_, ok = parseLFSPointer([]byte("version: 1"))
+	a.False(ok)
+	_, ok = parseLFSPointer([]byte(fmt.Sprintf("%s\noid sha256:abc\nsize 42\n", lfsPointerVersion)))
+	a.False(ok)
+}
+
+func TestLFSEndpoint(t *testing.T) {
+	a := assert.New(t)
+	for repoURL, expected := range map[string]string{
+		"https://git.example.org/org/repo":      "https://git.example.org/org/repo.git/info/lfs",
+		"https://git.example.org/org/repo.git/": "https://git.example.org/org/repo.git/info/lfs",
+		"git@git.example.org:org/repo.git":      "https://git.example.org/org/repo.git/info/lfs",
+		"ssh://git@git.example.org:22/org/repo": "https://git.example.org/org/repo.git/info/lfs",
+	} {
+		endpoint, err := lfsEndpoint(repoURL)
+		if a.NoError(err, repoURL) {
+			a.Equal(expected, endpoint, repoURL)
+		}
+	}
+
+	_, err := lfsEndpoint("/srv/git/repo")
+	a.Error(err)
+}
+
+func TestLFSRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	lfsServer := newFakeLFSServer(t)
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{
+		"model.bin":  lfsServer.pointer("model 1"),
+		"static.bin": lfsServer.pointer("static"),
+		"app.yaml":   "version: 1",
+	})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		LFS:       GitLFS{Enabled: true, URL: lfsServer.URL + "/repo.git/info/lfs"},
+		HTTPAuth:  GitHTTPAuth{Username: "konvahti", Password: "secret"},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(source.GetDirectory(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"model.bin", "static.bin", "app.yaml"}, changed)
+	a.Equal("model 1", readFile("model.bin"))
+	a.Equal("static", readFile("static.bin"))
+	a.Equal(2, lfsServer.downloads)
+
+	// Only the changed objects are downloaded, and the unchanged objects are kept in place
+	commitFiles(t, upstream, upstreamDir, map[string]string{"model.bin": lfsServer.pointer("model 2")})
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"model.bin"}, changed)
+	a.Equal("model 2", readFile("model.bin"))
+	a.Equal("static", readFile("static.bin"))
+	a.Equal(3, lfsServer.downloads)
+
+	// The previous checkout is kept when the objects can't be downloaded
+	missingPointer := fmt.Sprintf("%s\noid sha256:%s\nsize 7\n", lfsPointerVersion, strings.Repeat("0", 64))
+	commitFiles(t, upstream, upstreamDir, map[string]string{"model.bin": missingPointer})
+	_, err = source.Refresh(ctx)
+	a.Error(err)
+	a.Equal("model 2", readFile("model.bin"))
+
+	// Objects left out from the batch response fail the refresh, and the checkout is retried
+	lfsServer.omit(lfsOID("model 3"), true)
+	commitFiles(t, upstream, upstreamDir, map[string]string{"model.bin": lfsServer.pointer("model 3")})
+	_, err = source.Refresh(ctx)
+	a.Error(err)
+	a.Equal("model 2", readFile("model.bin"))
+
+	lfsServer.omit(lfsOID("model 3"), false)
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"model.bin"}, changed)
+	a.Equal("model 3", readFile("model.bin"))
+}
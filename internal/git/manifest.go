@@ -0,0 +1,93 @@
+package git
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+const manifestFilename = "konvahti-manifest.json"
+
+// fileManifest maps the file paths of a commit to the hashes of their contents.
+// It's used for listing the changed files when the previously checked out commit
+// is no longer found from the repository.
+type fileManifest map[string]string
+
+func commitManifest(repo *git.Repository, hash plumbing.Hash) (fileManifest, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(fileManifest)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		manifest[file.Name] = file.Hash.String()
+		return nil
+	})
+	return manifest, err
+}
+
+// changes lists the files that have been added, modified, or deleted since the previous manifest.
+func (m fileManifest) changes(prev fileManifest) changeset.ChangeSet {
+	var changes changeset.ChangeSet
+	for name, hash := range m {
+		if prevHash, ok := prev[name]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Added, Path: name})
+		} else if prevHash != hash {
+			changes = append(changes, changeset.Change{Kind: changeset.Modified, Path: name})
+		}
+	}
+	for name := range prev {
+		if _, ok := m[name]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Deleted, Path: name})
+		}
+	}
+	return changes
+}
+
+func (gs *GitSource) manifestPath() string {
+	return filepath.Join(gs.config.Directory, git.GitDirName, manifestFilename)
+}
+
+// readManifest reads the manifest recorded for the previous commit.
+// Nil is returned when no manifest has been recorded.
+func (gs *GitSource) readManifest() (fileManifest, error) {
+	data, err := os.ReadFile(gs.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest fileManifest
+	return manifest, json.Unmarshal(data, &manifest)
+}
+
+func (gs *GitSource) writeManifest(manifest fileManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tmpFilename := gs.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, gs.manifestPath())
+}
+
+// hasCommit checks whether the commit is found from the local repository.
+func (gs *GitSource) hasCommit(hash plumbing.Hash) (bool, error) {
+	_, err := gs.repository.CommitObject(hash)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
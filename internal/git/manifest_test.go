@@ -0,0 +1,85 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+func TestFileManifestChanges(t *testing.T) {
+	a := assert.New(t)
+	prev := fileManifest{"app.yaml": "1", "db.yaml": "1", "old.yaml": "1"}
+	cur := fileManifest{"app.yaml": "2", "db.yaml": "1", "new.yaml": "1"}
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "old.yaml"},
+		{Kind: changeset.Added, Path: "new.yaml"},
+	}, cur.changes(prev))
+}
+
+func TestMissingPreviousCommitRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1", "db.yaml": "host: localhost"})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Depth:     FullDepth,
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	a.Equal(0, source.cloneOptions.Depth)
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+
+	// Point the local branch to a commit that doesn't exist to simulate a gap in the history
+	missingHash := plumbing.NewHash(strings.Repeat("1", 40))
+	setLocalBranch := func() {
+		if err := source.repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", missingHash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setLocalBranch()
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2", "web.yaml": "port: 8080"})
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "web.yaml"}, changed)
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	// Without the manifest, all of the files are listed
+	setLocalBranch()
+	if err := os.Remove(source.manifestPath()); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"app.yaml", "db.yaml", "web.yaml"}, changed)
+	}
+}
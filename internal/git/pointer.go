@@ -0,0 +1,78 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// resolvePointer resolves the commit to check out from the pointer ref or the pointer file.
+// The commit is fetched to the local repository.
+func (gs *GitSource) resolvePointer(ctx context.Context) (plumbing.Hash, error) {
+	pointer := gs.config.Pointer
+	if pointer.Ref != "" {
+		ref := plumbing.ReferenceName(pointer.Ref)
+		return gs.fetchRef(ctx, ref, ref)
+	}
+
+	hash, err := gs.readPointerFile(ctx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	// The pointed commit is fetched from the tracked branch
+	if _, err := gs.fetchRef(ctx, plumbing.NewBranchReferenceName(gs.config.Branch), gs.remoteBranchRef()); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := gs.repository.CommitObject(hash); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("commit %s from the pointer file not found from branch %s: %w", hash, gs.config.Branch, err)
+	}
+	return hash, nil
+}
+
+// readPointerFile reads the commit hash from the pointer file.
+// Only the latest commit of the pointer repository is fetched, and it's kept in memory.
+func (gs *GitSource) readPointerFile(ctx context.Context) (plumbing.Hash, error) {
+	pointer := gs.config.Pointer
+	url := pointer.URL
+	if url == "" {
+		url = gs.config.URL
+	}
+
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           url,
+		Auth:          gs.cloneOptions.Auth,
+		ReferenceName: plumbing.NewBranchReferenceName(pointer.Branch),
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to fetch pointer repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	file, err := commit.File(pointer.File)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read pointer file %s: %w", pointer.File, err)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	value := strings.TrimSpace(contents)
+	if !plumbing.IsHash(value) {
+		return plumbing.ZeroHash, fmt.Errorf("pointer file %s doesn't contain a commit hash", pointer.File)
+	}
+	return plumbing.NewHash(value), nil
+}
@@ -0,0 +1,121 @@
+package git
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// The last commit whose changes were acknowledged is recorded with this reference,
+// so that the replay continues from it after a restart.
+const replayRefName plumbing.ReferenceName = "refs/konvahti/replay"
+
+// replayPlan lists the commits that are checked out one at a time.
+type replayPlan struct {
+	// The commit that the plan continues from
+	base plumbing.Hash
+	// The commits to check out in order
+	commits []plumbing.Hash
+}
+
+// Acknowledge marks the changes from the checked out commit applied.
+// In replay mode, the changes from the same commit are listed again on the next refresh until they are acknowledged.
+func (gs *GitSource) Acknowledge() error {
+	if !gs.replayStepPending {
+		return nil
+	}
+	ref := plumbing.NewHashReference(replayRefName, gs.replayStepHash)
+	if err := gs.repository.Storer.SetReference(ref); err != nil {
+		return fmt.Errorf("failed to record acknowledged Git commit: %w", err)
+	}
+	gs.replayStepPending = false
+	gs.replayStepFiles = nil
+	return nil
+}
+
+// HasMoreSteps tells whether there are commits left to replay after the checked out commit.
+func (gs *GitSource) HasMoreSteps() bool {
+	return gs.replayRemaining
+}
+
+// replayBase returns the last acknowledged commit, which the changes are listed against instead of
+// the checked out commit. The checked out commit might not be acknowledged before a restart.
+// Nil is returned when no commit has been acknowledged yet.
+func (gs *GitSource) replayBase() (*plumbing.Reference, error) {
+	ref, err := gs.repository.Storer.Reference(replayRefName)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	return ref, err
+}
+
+// nextReplayCommit returns the commit to check out after the previous commit on the way to the target.
+// The commits between them are listed once, and the same list is followed until the target changes.
+func (gs *GitSource) nextReplayCommit(prev plumbing.Hash, target plumbing.Hash) (plumbing.Hash, error) {
+	plan := gs.replayPlan
+	if plan.base != prev || len(plan.commits) == 0 || plan.commits[len(plan.commits)-1] != target {
+		commits, err := replayCommits(gs.repository, prev, target)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		plan = replayPlan{base: prev, commits: commits}
+	}
+	next := plan.commits[0]
+	gs.replayPlan = replayPlan{base: next, commits: plan.commits[1:]}
+	return next, nil
+}
+
+// replayCommits lists the commits reachable from the target but not from the previous commit,
+// so that each commit is listed after its parents. The first-parent history is listed before
+// the other parents of merge commits. Only the target is listed when the previous commit
+// isn't found from the history of the target (e.g. beyond the fetched history).
+func replayCommits(repo *git.Repository, prev plumbing.Hash, target plumbing.Hash) ([]plumbing.Hash, error) {
+	excluded, err := reachableCommits(repo, prev, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []plumbing.Hash
+	prevFound := false
+	visited := make(map[plumbing.Hash]bool)
+	listed := make(map[plumbing.Hash]bool)
+	stack := []plumbing.Hash{target}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		if excluded[hash] || listed[hash] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if visited[hash] {
+			// All of the parents have been listed
+			stack = stack[:len(stack)-1]
+			listed[hash] = true
+			commits = append(commits, hash)
+			continue
+		}
+		visited[hash] = true
+
+		commit, err := repo.CommitObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			return []plumbing.Hash{target}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The stack is processed from the end, so the first parent is pushed last
+		for i := len(commit.ParentHashes) - 1; i >= 0; i-- {
+			parent := commit.ParentHashes[i]
+			if parent == prev {
+				prevFound = true
+			}
+			if !visited[parent] && !excluded[parent] {
+				stack = append(stack, parent)
+			}
+		}
+	}
+	if !prevFound {
+		return []plumbing.Hash{target}, nil
+	}
+	return commits, nil
+}
@@ -0,0 +1,201 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Replay:    true,
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	a.False(source.HasMoreSteps())
+	assertHead(t, &source, first)
+	a.NoError(source.Acknowledge())
+
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{"db.yaml": "host: localhost"})
+
+	// Each commit is checked out separately
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	a.True(source.HasMoreSteps())
+	assertHead(t, &source, second)
+
+	// Unacknowledged changes are listed again without moving on to the next commit
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	assertHead(t, &source, second)
+	a.NoError(source.Acknowledge())
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"db.yaml"}, changed)
+	a.False(source.HasMoreSteps())
+	assertHead(t, &source, third)
+	a.NoError(source.Acknowledge())
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+	a.False(source.HasMoreSteps())
+}
+
+func assertHead(t *testing.T, source *GitSource, hash plumbing.Hash) {
+	head, err := source.repository.Head()
+	if assert.NoError(t, err) {
+		assert.Equal(t, hash, head.Hash())
+	}
+}
+
+func TestReplayRefreshAfterRestart(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	directory := filepath.Join(t.TempDir(), "repo")
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	config := Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Replay:    true,
+		Directory: directory,
+	}
+
+	var source GitSource
+	if err := source.Setup(config); !a.NoError(err) {
+		return
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	a.NoError(source.Acknowledge())
+
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{"db.yaml": "host: localhost"})
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+	assertHead(t, &source, second)
+
+	// The commit that wasn't acknowledged before the restart is replayed again
+	var restarted GitSource
+	if err := restarted.Setup(config); !a.NoError(err) {
+		return
+	}
+	changed, err := restarted.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	a.True(restarted.HasMoreSteps())
+	assertHead(t, &restarted, second)
+	a.NoError(restarted.Acknowledge())
+
+	changed, err = restarted.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"db.yaml"}, changed)
+	a.False(restarted.HasMoreSteps())
+	assertHead(t, &restarted, third)
+}
+
+func TestReplayCommitsWithMerge(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	wt, err := upstream.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: "refs/heads/side", Hash: base, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	side := commitFiles(t, upstream, upstreamDir, map[string]string{"db.yaml": "host: localhost"})
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: "refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+	mainline := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	if err := os.WriteFile(filepath.Join(upstreamDir, "db.yaml"), []byte("host: localhost"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("db.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	merge, err := wt.Commit("merge", &git.CommitOptions{
+		Author:  &object.Signature{Name: "Konvahti", Email: "konvahti@example.org", When: time.Now()},
+		Parents: []plumbing.Hash{mainline, side},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each commit is listed after its parents, and the first parent history comes first
+	commits, err := replayCommits(upstream, base, merge)
+	if a.NoError(err) {
+		a.Equal([]plumbing.Hash{mainline, side, merge}, commits)
+	}
+
+	// The target is checked out directly when the previous commit isn't in its history
+	commits, err = replayCommits(upstream, side, mainline)
+	if a.NoError(err) {
+		a.Equal([]plumbing.Hash{mainline}, commits)
+	}
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+)
+
+// With snapshots, the repository is cloned to this sub-directory of the configured directory
+const snapshotRepositoryDirectory = "repository"
+
+// publishChanges publishes a snapshot of the checked out files. The changed files are kept
+// until they are published, so that the actions are run for them once the snapshot is available.
+func (gs *GitSource) publishChanges(files []string, logger zerolog.Logger) ([]string, error) {
+	gs.snapshotFiles = mergeFiles(gs.snapshotFiles, files)
+	if err := gs.publishSnapshot(logger); err != nil {
+		return nil, fmt.Errorf("failed to publish Git snapshot: %w", err)
+	}
+	files = gs.snapshotFiles
+	gs.snapshotFiles = nil
+	return files, nil
+}
+
+// publishSnapshot exports the checked out files to a directory named by the commit hash,
+// and swaps it in as the latest snapshot. The previous snapshot is kept for the actions
+// that might still use it, and the older snapshots are removed.
+func (gs *GitSource) publishSnapshot(logger zerolog.Logger) error {
+	head, err := gs.repository.Head()
+	if err != nil {
+		return err
+	}
+	snapshot := head.Hash().String()
+	previous, err := gs.snapshotFs.Readlink(file.LatestLinkName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if previous == snapshot {
+		return nil
+	}
+
+	// A snapshot of the same commit might be left from earlier, when the commit moves backwards
+	if err := util.RemoveAll(gs.snapshotFs, snapshot); err != nil {
+		return err
+	}
+	logger.Debug().Str("snapshot", snapshot).Msg("publishing snapshot")
+	if err := file.SwapDirectory(gs.snapshotFs, file.LatestLinkName, snapshot, func(fs billy.Filesystem) error {
+		return exportWorkingDirectory(gs.config.Directory, fs)
+	}); err != nil {
+		return err
+	}
+	return gs.removeOldSnapshots(snapshot, previous)
+}
+
+func (gs *GitSource) removeOldSnapshots(current string, previous string) error {
+	entries, err := gs.snapshotFs.ReadDir("")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !plumbing.IsHash(name) || name == current || name == previous {
+			continue
+		}
+		if err := util.RemoveAll(gs.snapshotFs, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportWorkingDirectory copies the files from the working directory excluding the repository metadata.
+// Submodules and LFS objects are included as they are checked out.
+func exportWorkingDirectory(directory string, targetFs billy.Filesystem) error {
+	return filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == directory {
+			return err
+		}
+		if entry.Name() == git.GitDirName {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			// Submodules refer to their repositories using .git files
+			return nil
+		}
+		name, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return targetFs.MkdirAll(name, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return targetFs.Symlink(target, name)
+		default:
+			return exportFile(path, targetFs, name, info.Mode().Perm())
+		}
+	})
+}
+
+func exportFile(path string, targetFs billy.Filesystem, name string, perm os.FileMode) error {
+	source, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := targetFs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
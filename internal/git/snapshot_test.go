@@ -0,0 +1,91 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+
+	directory := filepath.Join(t.TempDir(), "repo")
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       upstreamDir,
+		Branch:    "main",
+		Directory: directory,
+		Snapshots: true,
+	}); !a.NoError(err) {
+		return
+	}
+	a.Equal(filepath.Join(directory, "latest"), source.GetDirectory())
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	assertSnapshot(t, directory, first)
+	a.NoFileExists(filepath.Join(source.GetDirectory(), ".git"))
+
+	// The previous snapshot stays in place for the actions that still use it
+	second := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	assertSnapshot(t, directory, second)
+	a.DirExists(filepath.Join(directory, first.String()))
+
+	data, err := os.ReadFile(filepath.Join(directory, first.String(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+	data, err = os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	// Older snapshots are removed
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{"web.yaml": "port: 8080"})
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"web.yaml"}, changed)
+	assertSnapshot(t, directory, third)
+	a.DirExists(filepath.Join(directory, second.String()))
+	a.NoDirExists(filepath.Join(directory, first.String()))
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+	assertSnapshot(t, directory, third)
+}
+
+func assertSnapshot(t *testing.T, directory string, hash plumbing.Hash) {
+	target, err := os.Readlink(filepath.Join(directory, "latest"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, hash.String(), target)
+	}
+}
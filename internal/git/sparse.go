@@ -0,0 +1,186 @@
+package git
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// pathFilter limits the files to the included paths and the files under them.
+// An empty filter includes all files.
+type pathFilter []string
+
+func newPathFilter(paths []string) pathFilter {
+	filter := make(pathFilter, 0, len(paths))
+	for _, p := range paths {
+		filter = append(filter, path.Clean(strings.Trim(p, "/")))
+	}
+	return filter
+}
+
+func (f pathFilter) includes(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, p := range f {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f pathFilter) filter(files []string) []string {
+	if len(f) == 0 {
+		return files
+	}
+	var filtered []string
+	for _, file := range files {
+		if f.includes(file) {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
+
+// checkoutSparse writes only the included files to the working directory, and points HEAD to the commit.
+// Unlike with regular checkouts, the index is not updated.
+// When the previous commit is not given, all files except the repository metadata are replaced.
+// HEAD is only moved once all of the files are written, so that a failed checkout is retried from the previous commit.
+func (gs *GitSource) checkoutSparse(target checkoutTarget, prevHead *plumbing.Reference) error {
+	repo := gs.repository
+	curCommit, err := repo.CommitObject(target.hash)
+	if err != nil {
+		return err
+	}
+	curTree, err := curCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	var prevTree *object.Tree
+	if prevHead != nil {
+		prevCommit, err := repo.CommitObject(prevHead.Hash())
+		if err != nil {
+			return err
+		}
+		if prevTree, err = prevCommit.Tree(); err != nil {
+			return err
+		}
+	} else if err := gs.clearWorkingDirectory(); err != nil {
+		return err
+	}
+
+	changes, err := object.DiffTree(prevTree, curTree)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.From.Name != "" && change.To.Name != change.From.Name && gs.pathFilter.includes(change.From.Name) {
+			if err := gs.removeWorkingFile(change.From.Name); err != nil {
+				return err
+			}
+		}
+		if change.To.Name != "" && gs.pathFilter.includes(change.To.Name) {
+			if err := gs.writeWorkingFile(curTree, change.To.Name, change.To.TreeEntry); err != nil {
+				return err
+			}
+		}
+	}
+
+	if target.branch != "" {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(target.branch, target.hash)); err != nil {
+			return err
+		}
+		return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, target.branch))
+	}
+	return repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, target.hash))
+}
+
+// clearWorkingDirectory removes everything except the repository metadata from the working directory.
+func (gs *GitSource) clearWorkingDirectory() error {
+	entries, err := os.ReadDir(gs.config.Directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == git.GitDirName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(gs.config.Directory, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gs *GitSource) writeWorkingFile(tree *object.Tree, name string, entry object.TreeEntry) error {
+	if entry.Mode == filemode.Submodule || entry.Mode == filemode.Dir {
+		return nil
+	}
+	file, err := tree.TreeEntryFile(&entry)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(gs.config.Directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filename); err != nil {
+		return err
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if entry.Mode == filemode.Symlink {
+		target, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), filename)
+	}
+
+	perm := os.FileMode(0644)
+	if entry.Mode == filemode.Executable {
+		perm = 0755
+	}
+	f, err := os.OpenFile(filepath.Clean(filename), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeWorkingFile removes the file, and the parent directories that became empty.
+func (gs *GitSource) removeWorkingFile(name string) error {
+	filename := filepath.Join(gs.config.Directory, filepath.FromSlash(name))
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(filename); dir != filepath.Clean(gs.config.Directory); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathFilter(t *testing.T) {
+	a := assert.New(t)
+	filter := newPathFilter([]string{"/apps/edge/", "config.yaml"})
+
+	a.True(filter.includes("apps/edge"))
+	a.True(filter.includes("apps/edge/app.yaml"))
+	a.True(filter.includes("config.yaml"))
+	a.False(filter.includes("apps/edge-old/app.yaml"))
+	a.False(filter.includes("apps/cloud/app.yaml"))
+	a.Equal([]string{"apps/edge/app.yaml"}, filter.filter([]string{"apps/cloud/app.yaml", "apps/edge/app.yaml"}))
+	a.Equal([]string{"README.md"}, newPathFilter(nil).filter([]string{"README.md"}))
+}
+
+func TestSparseRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{
+		"apps/edge/app.yaml":  "version: 1",
+		"apps/cloud/app.yaml": "version: 1",
+		"README.md":           "docs",
+	})
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:          upstreamDir,
+		Branch:       "main",
+		IncludePaths: []string{"apps/edge"},
+		Directory:    filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"apps/edge", "apps/edge/app.yaml"}, changed)
+	a.FileExists(filepath.Join(source.GetDirectory(), "apps/edge/app.yaml"))
+	a.NoFileExists(filepath.Join(source.GetDirectory(), "apps/cloud/app.yaml"))
+	a.NoFileExists(filepath.Join(source.GetDirectory(), "README.md"))
+
+	// Commits outside the included paths don't produce changes
+	commitFiles(t, upstream, upstreamDir, map[string]string{"apps/cloud/app.yaml": "version: 2"})
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+	a.NoDirExists(filepath.Join(source.GetDirectory(), "apps/cloud"))
+
+	commitFiles(t, upstream, upstreamDir, map[string]string{
+		"apps/edge/app.yaml":  "",
+		"apps/edge/new.yaml":  "version: 3",
+		"apps/cloud/app.yaml": "version: 3",
+	})
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"apps/edge/app.yaml", "apps/edge/new.yaml"}, changed)
+	a.NoFileExists(filepath.Join(source.GetDirectory(), "apps/edge/app.yaml"))
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "apps/edge/new.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 3", string(data))
+	}
+
+	// Files outside the included paths are removed when the repository is opened again
+	if err := os.WriteFile(filepath.Join(source.GetDirectory(), "README.md"), []byte("docs"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"apps/edge/new.yaml": "version: 4"})
+	var reopened GitSource
+	if err := reopened.Setup(source.config); !a.NoError(err) {
+		return
+	}
+	changed, err = reopened.Refresh(ctx)
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"apps/edge/new.yaml"}, changed)
+	}
+	a.NoFileExists(filepath.Join(reopened.GetDirectory(), "README.md"))
+	a.FileExists(filepath.Join(reopened.GetDirectory(), "apps/edge/new.yaml"))
+}
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"context"
+	"path"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// updateSubmodules initializes the submodules, and checks out the commits
+// recorded in the superproject recursively.
+func (gs *GitSource) updateSubmodules(ctx context.Context) error {
+	wt, err := gs.repository.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := wt.Submodules()
+	if err != nil {
+		return err
+	}
+	return submodules.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              gs.cloneOptions.Auth,
+	})
+}
+
+// submoduleChangedFiles lists the files changed inside the submodules that have
+// moved between the two commits of the repository. The files are prefixed with
+// the submodule paths. The previous commit can be nil, in which case all of the
+// files in the submodules are listed.
+func submoduleChangedFiles(repo *git.Repository, prevCommit, curCommit *object.Commit) ([]string, error) {
+	var prevTree, curTree *object.Tree
+	var err error
+	if prevCommit != nil {
+		if prevTree, err = prevCommit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	if curTree, err = curCommit.Tree(); err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(prevTree, curTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, change := range changes {
+		if change.To.TreeEntry.Mode != filemode.Submodule {
+			// Removed submodules are only listed using their paths
+			continue
+		}
+		submodulePath := change.To.Name
+		submoduleRepo, err := submoduleRepository(repo, submodulePath)
+		if err != nil {
+			return nil, err
+		}
+
+		// When the previous commit isn't found from the submodule, all of the files are listed
+		var submodulePrevCommit *object.Commit
+		if change.From.TreeEntry.Mode == filemode.Submodule {
+			submodulePrevCommit, err = submoduleRepo.CommitObject(change.From.TreeEntry.Hash)
+			if err != nil && err != plumbing.ErrObjectNotFound {
+				return nil, err
+			}
+		}
+		submoduleCurCommit, err := submoduleRepo.CommitObject(change.To.TreeEntry.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		submoduleFiles, err := commitChangedFiles(submodulePrevCommit, submoduleCurCommit)
+		if err != nil {
+			return nil, err
+		}
+		nestedFiles, err := submoduleChangedFiles(submoduleRepo, submodulePrevCommit, submoduleCurCommit)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range append(submoduleFiles, nestedFiles...) {
+			files = append(files, path.Join(submodulePath, file))
+		}
+	}
+	return files, nil
+}
+
+// commitChangedFiles lists the files changed between the commits.
+// The previous commit can be nil, in which case all of the files are listed.
+func commitChangedFiles(prevCommit, curCommit *object.Commit) ([]string, error) {
+	var prevTree *object.Tree
+	var err error
+	if prevCommit != nil {
+		if prevTree, err = prevCommit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	curTree, err := curCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(prevTree, curTree)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(changes))
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+func submoduleRepository(repo *git.Repository, submodulePath string) (*git.Repository, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	submodules, err := wt.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	for _, submodule := range submodules {
+		if submodule.Config().Path == submodulePath {
+			return submodule.Repository()
+		}
+	}
+	return nil, git.ErrSubmoduleNotFound
+}
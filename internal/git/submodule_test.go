@@ -0,0 +1,98 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=Konvahti",
+		"-c", "user.email=konvahti@example.org",
+		"-c", "protocol.file.allow=always",
+	}, args...)...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %s: %s", args, err, output)
+	}
+}
+
+func writeFile(t *testing.T, filename string, content string) {
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmoduleRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	sharedDir := t.TempDir()
+	upstreamDir := t.TempDir()
+
+	runGit(t, sharedDir, "init", "-q", "-b", "main")
+	writeFile(t, filepath.Join(sharedDir, "common.yaml"), "version: 1")
+	runGit(t, sharedDir, "add", ".")
+	runGit(t, sharedDir, "commit", "-q", "-m", "initial")
+
+	runGit(t, upstreamDir, "init", "-q", "-b", "main")
+	writeFile(t, filepath.Join(upstreamDir, "app.yaml"), "version: 1")
+	runGit(t, upstreamDir, "add", ".")
+	runGit(t, upstreamDir, "submodule", "add", "-q", sharedDir, "shared")
+	runGit(t, upstreamDir, "commit", "-q", "-m", "initial")
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:        upstreamDir,
+		Branch:     "main",
+		Submodules: true,
+		Directory:  filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{".gitmodules", "app.yaml", "shared", "shared/common.yaml"}, changed)
+	a.ElementsMatch(changeset.FromPaths(changeset.Added, changed), source.Changes())
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "shared", "common.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+
+	// Changes in the submodule are only picked up when the superproject moves the submodule pointer
+	writeFile(t, filepath.Join(sharedDir, "common.yaml"), "version: 2")
+	writeFile(t, filepath.Join(sharedDir, "extra.yaml"), "extra: true")
+	runGit(t, sharedDir, "add", ".")
+	runGit(t, sharedDir, "commit", "-q", "-m", "update")
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+
+	runGit(t, filepath.Join(upstreamDir, "shared"), "pull", "-q", "origin", "main")
+	runGit(t, upstreamDir, "commit", "-q", "-am", "update shared")
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"shared/common.yaml", "shared/extra.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "shared/common.yaml"},
+		{Kind: changeset.Modified, Path: "shared/extra.yaml"},
+	}, source.Changes())
+	data, err = os.ReadFile(filepath.Join(source.GetDirectory(), "shared", "common.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+}
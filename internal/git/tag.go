@@ -0,0 +1,122 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/gobwas/glob"
+)
+
+// tagMatcher selects tags using either a semantic version constraint (e.g. ">=2.3.0 <3.0.0")
+// or a glob pattern (e.g. "release-*").
+type tagMatcher struct {
+	constraint *semver.Constraints
+	pattern    glob.Glob
+}
+
+func newTagMatcher(expr string) (*tagMatcher, error) {
+	if constraint, err := semver.NewConstraint(expr); err == nil {
+		return &tagMatcher{constraint: constraint}, nil
+	}
+	pattern, err := glob.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s is neither a semantic version constraint nor a glob pattern: %w", expr, err)
+	}
+	return &tagMatcher{pattern: pattern}, nil
+}
+
+func (m *tagMatcher) matches(tag string) bool {
+	if m.pattern != nil {
+		return m.pattern.Match(tag)
+	}
+	version, err := semver.NewVersion(tag)
+	return err == nil && m.constraint.Check(version)
+}
+
+// highest picks the highest matching tag. Tags are ordered by their semantic versions,
+// and tags that are not semantic versions are ordered by their names below the versions.
+func (m *tagMatcher) highest(tags []string) (best string, found bool) {
+	for _, tag := range tags {
+		if !m.matches(tag) {
+			continue
+		}
+		if !found || tagLess(best, tag) {
+			best, found = tag, true
+		}
+	}
+	return
+}
+
+func tagLess(a, b string) bool {
+	versionA, errA := semver.NewVersion(a)
+	versionB, errB := semver.NewVersion(b)
+	switch {
+	case errA == nil && errB == nil && !versionA.Equal(versionB):
+		return versionA.LessThan(versionB)
+	case errA == nil && errB != nil:
+		return false
+	case errA != nil && errB == nil:
+		return true
+	default:
+		return a < b
+	}
+}
+
+// latestRemoteTag finds the highest matching tag from the remote repository.
+func (gs *GitSource) latestRemoteTag(ctx context.Context) (*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gs.config.URL},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: gs.cloneOptions.Auth})
+	if err != nil {
+		return nil, err
+	}
+
+	tagRefs := make(map[string]*plumbing.Reference)
+	tags := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if !ref.Name().IsTag() || strings.HasSuffix(ref.Name().String(), "^{}") {
+			continue
+		}
+		tag := ref.Name().Short()
+		tagRefs[tag] = ref
+		tags = append(tags, tag)
+	}
+
+	tag, ok := gs.tagMatcher.highest(tags)
+	if !ok {
+		return nil, fmt.Errorf("no tags matching %s found", gs.config.Tag)
+	}
+	return tagRefs[tag], nil
+}
+
+// localTagAtHead finds the name of the matching local tag that points to the current HEAD.
+// This is used for finding the deployed tag when a repository is found from the file system.
+func (gs *GitSource) localTagAtHead() string {
+	head, err := gs.repository.Head()
+	if err != nil {
+		return ""
+	}
+	tagIter, err := gs.repository.Tags()
+	if err != nil {
+		return ""
+	}
+	defer tagIter.Close()
+
+	var tags []string
+	_ = tagIter.ForEach(func(ref *plumbing.Reference) error {
+		if commitHash, err := resolveCommitHash(gs.repository, ref.Hash()); err == nil && commitHash == head.Hash() {
+			tags = append(tags, ref.Name().Short())
+		}
+		return nil
+	})
+	tag, _ := gs.tagMatcher.highest(tags)
+	return tag
+}
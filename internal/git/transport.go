@@ -0,0 +1,96 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// The Git HTTP transports are shared by all of the repositories, so the HTTP clients
+// with custom TLS and proxy settings are passed to the transport with the authentication
+// method of each operation. This way the clients are not shared between the sources,
+// and they are also used for the submodules.
+var installTransportOnce sync.Once
+
+// httpTransport is the Git HTTP transport that uses the HTTP client carried by the authentication method.
+// The default HTTP client is used for operations without a client.
+type httpTransport struct{}
+
+func (httpTransport) NewUploadPackSession(
+	ep *transport.Endpoint,
+	auth transport.AuthMethod,
+) (transport.UploadPackSession, error) {
+	c, auth := httpClientFor(auth)
+	return c.NewUploadPackSession(ep, auth)
+}
+
+func (httpTransport) NewReceivePackSession(
+	ep *transport.Endpoint,
+	auth transport.AuthMethod,
+) (transport.ReceivePackSession, error) {
+	c, auth := httpClientFor(auth)
+	return c.NewReceivePackSession(ep, auth)
+}
+
+func httpClientFor(auth transport.AuthMethod) (transport.Transport, transport.AuthMethod) {
+	if a, ok := auth.(*httpClientAuth); ok {
+		return a.client, a.auth
+	}
+	return githttp.DefaultClient, auth
+}
+
+// httpClientAuth carries the HTTP client of a source to the Git HTTP transport
+// along with the HTTP authentication method (if any).
+type httpClientAuth struct {
+	client transport.Transport
+	auth   transport.AuthMethod
+}
+
+func (a *httpClientAuth) Name() string {
+	if a.auth == nil {
+		return "http-client"
+	}
+	return a.auth.Name()
+}
+
+func (a *httpClientAuth) String() string {
+	if a.auth == nil {
+		return a.Name()
+	}
+	return a.auth.String()
+}
+
+// withHTTPClient makes the Git HTTP transport use the HTTP client for the operations using the authentication method.
+// Authentication methods for other protocols (e.g. SSH) are returned as is.
+func withHTTPClient(auth transport.AuthMethod, httpClient *http.Client) transport.AuthMethod {
+	if _, ok := auth.(githttp.AuthMethod); auth != nil && !ok {
+		return auth
+	}
+	installTransportOnce.Do(func() {
+		client.InstallProtocol("http", httpTransport{})
+		client.InstallProtocol("https", httpTransport{})
+	})
+	return &httpClientAuth{client: githttp.NewClient(httpClient), auth: auth}
+}
+
+// withProxy makes the HTTP client use the proxy instead of the proxy from the environment variables.
+func withProxy(httpClient *http.Client, proxyURL string) (*http.Client, error) {
+	if proxyURL == "" {
+		return httpClient, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %s: %w", proxyURL, err)
+	}
+	t, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unsupported HTTP transport for proxy")
+	}
+	t.Proxy = http.ProxyURL(u)
+	return httpClient, nil
+}
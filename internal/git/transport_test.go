@@ -0,0 +1,195 @@
+package git
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+// gitHTTPHandler serves the repositories in the directory using the Git smart HTTP protocol.
+func gitHTTPHandler(t *testing.T, projectRoot string) http.Handler {
+	requireGit(t)
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend")
+	if _, err := os.Stat(backend); err != nil {
+		t.Skip("git-http-backend not found")
+	}
+	return &cgi.Handler{
+		Path: backend,
+		Env:  []string{"GIT_PROJECT_ROOT=" + projectRoot, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+}
+
+func newHTTPUpstream(t *testing.T) (string, *git.Repository) {
+	projectRoot := t.TempDir()
+	upstreamDir := filepath.Join(projectRoot, "repo")
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	return projectRoot, upstream
+}
+
+func TestCustomCARefresh(t *testing.T) {
+	a := assert.New(t)
+	projectRoot, _ := newHTTPUpstream(t)
+	server := httptest.NewTLSServer(gitHTTPHandler(t, projectRoot))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       server.URL + "/repo",
+		Branch:    "main",
+		TLS:       httpclient.TLSConfig{CAFile: caFile},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(context.Background())
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"app.yaml"}, changed)
+	}
+
+	// Without the CA, the server certificate is not trusted
+	otherServer := httptest.NewTLSServer(gitHTTPHandler(t, projectRoot))
+	defer otherServer.Close()
+	var untrusted GitSource
+	if err := untrusted.Setup(Config{
+		URL:       otherServer.URL + "/repo",
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	_, err = untrusted.Refresh(context.Background())
+	a.Error(err)
+}
+
+func TestProxyRefresh(t *testing.T) {
+	a := assert.New(t)
+	projectRoot, _ := newHTTPUpstream(t)
+	handler := gitHTTPHandler(t, projectRoot)
+	var proxiedRequests int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The proxy serves the repository itself instead of forwarding the requests
+		if r.URL.Host == "git.internal.example.org" {
+			atomic.AddInt32(&proxiedRequests, 1)
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:       "http://git.internal.example.org/repo",
+		Branch:    "main",
+		ProxyURL:  proxy.URL,
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(context.Background())
+	if a.NoError(err) {
+		a.ElementsMatch([]string{"app.yaml"}, changed)
+	}
+	a.NotZero(atomic.LoadInt32(&proxiedRequests))
+}
+
+func TestHTTPClientPerSource(t *testing.T) {
+	a := assert.New(t)
+	projectRoot, _ := newHTTPUpstream(t)
+	server := httptest.NewTLSServer(gitHTTPHandler(t, projectRoot))
+	defer server.Close()
+
+	var insecure GitSource
+	if err := insecure.Setup(Config{
+		URL:       server.URL + "/repo",
+		Branch:    "main",
+		TLS:       httpclient.TLSConfig{InsecureSkipVerify: true},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	_, err := insecure.Refresh(context.Background())
+	a.NoError(err)
+
+	// The TLS settings of the other source are not used for the same URL
+	var secure GitSource
+	if err := secure.Setup(Config{
+		URL:       server.URL + "/repo",
+		Branch:    "main",
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	_, err = secure.Refresh(context.Background())
+	a.Error(err)
+}
+
+func TestCustomCASubmoduleRefresh(t *testing.T) {
+	a := assert.New(t)
+	projectRoot, _ := newHTTPUpstream(t)
+	server := httptest.NewTLSServer(gitHTTPHandler(t, projectRoot))
+	defer server.Close()
+
+	sharedDir := filepath.Join(projectRoot, "shared")
+	if err := os.Mkdir(sharedDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, sharedDir, "init", "-q", "-b", "main")
+	writeFile(t, filepath.Join(sharedDir, "common.yaml"), "version: 1")
+	runGit(t, sharedDir, "add", ".")
+	runGit(t, sharedDir, "commit", "-q", "-m", "initial")
+	upstreamDir := filepath.Join(projectRoot, "repo")
+	runGit(t, upstreamDir, "-c", "http.sslVerify=false", "submodule", "add", "-q", server.URL+"/shared", "shared")
+	runGit(t, upstreamDir, "commit", "-q", "-m", "add submodule")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:        server.URL + "/repo",
+		Branch:     "main",
+		Submodules: true,
+		TLS:        httpclient.TLSConfig{CAFile: caFile},
+		Directory:  filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(context.Background())
+	if a.NoError(err) {
+		a.ElementsMatch([]string{".gitmodules", "app.yaml", "shared", "shared/common.yaml"}, changed)
+	}
+}
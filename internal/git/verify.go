@@ -0,0 +1,287 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pgpSignaturePrefix = "-----BEGIN PGP SIGNATURE-----"
+	sshSignaturePrefix = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureMagic  = "SSHSIG"
+	sshSignatureType   = "SSH SIGNATURE"
+	// Git signs commits using this namespace
+	sshSignatureNamespace = "git"
+)
+
+var errUnverifiedCommit = errors.New("commit signature verification failed")
+
+// signatureVerifier verifies that commits are signed using trusted OpenPGP or SSH keys.
+type signatureVerifier struct {
+	pgpKeyring openpgp.EntityList
+	sshKeys    []ssh.PublicKey
+	allCommits bool
+}
+
+func newSignatureVerifier(config *GitSignatures) (*signatureVerifier, error) {
+	v := &signatureVerifier{allCommits: config.AllCommits}
+	if config.PGPKeyringPath != "" {
+		keyring, err := readPGPKeyring(config.PGPKeyringPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OpenPGP keyring %s: %w", config.PGPKeyringPath, err)
+		}
+		v.pgpKeyring = keyring
+	}
+	if config.SSHAllowedSignersPath != "" {
+		keys, err := readSSHAllowedSigners(config.SSHAllowedSignersPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH allowed signers %s: %w", config.SSHAllowedSignersPath, err)
+		}
+		v.sshKeys = keys
+	}
+	return v, nil
+}
+
+func readPGPKeyring(filename string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// readSSHAllowedSigners reads the public keys from a file in the Git allowed signers format
+// (principals, options, and key) or in the authorized keys format (options and key).
+func readSSHAllowedSigners(filename string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			// Skip the principals field of the allowed signers format
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) < 2 {
+				return nil, err
+			}
+			if key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(fields[1])); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found")
+	}
+	return keys, scanner.Err()
+}
+
+// verify verifies the target commit, and when all commits are verified,
+// the commits between the previous commit and the target commit.
+// Verification fails when the commits between them are beyond the fetched history.
+func (v *signatureVerifier) verify(repo *git.Repository, prev plumbing.Hash, target plumbing.Hash) error {
+	commits := []plumbing.Hash{target}
+	if v.allCommits && !prev.IsZero() {
+		var err error
+		if commits, err = commitsBetween(repo, prev, target); err != nil {
+			return err
+		}
+	}
+	for _, hash := range commits {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return err
+		}
+		if err := v.verifyCommit(commit); err != nil {
+			return fmt.Errorf("%w for commit %s: %s", errUnverifiedCommit, hash, err)
+		}
+	}
+	return nil
+}
+
+func (v *signatureVerifier) verifyCommit(commit *object.Commit) error {
+	signature := strings.TrimSpace(commit.PGPSignature)
+	if signature == "" {
+		return fmt.Errorf("commit is not signed")
+	}
+
+	payload := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(payload); err != nil {
+		return err
+	}
+	payloadReader, err := payload.Reader()
+	if err != nil {
+		return err
+	}
+	defer payloadReader.Close()
+
+	switch {
+	case strings.HasPrefix(signature, pgpSignaturePrefix):
+		if len(v.pgpKeyring) == 0 {
+			return fmt.Errorf("no trusted OpenPGP keys configured")
+		}
+		_, err := openpgp.CheckArmoredDetachedSignature(v.pgpKeyring, payloadReader, strings.NewReader(signature), nil)
+		return err
+	case strings.HasPrefix(signature, sshSignaturePrefix):
+		if len(v.sshKeys) == 0 {
+			return fmt.Errorf("no trusted SSH keys configured")
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(payloadReader); err != nil {
+			return err
+		}
+		return v.verifySSHSignature(buf.Bytes(), signature)
+	default:
+		return fmt.Errorf("unsupported signature format")
+	}
+}
+
+// sshSignature is the SSHSIG signature format used by ssh-keygen -Y sign.
+type sshSignature struct {
+	Magic         [6]byte
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is the data that is actually signed in the SSHSIG format.
+type sshSignedData struct {
+	Magic         [6]byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func (v *signatureVerifier) verifySSHSignature(payload []byte, armored string) error {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != sshSignatureType {
+		return fmt.Errorf("invalid SSH signature")
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(block.Bytes, &sig); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	if string(sig.Magic[:]) != sshSignatureMagic || sig.Version != 1 {
+		return fmt.Errorf("unsupported SSH signature version")
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return fmt.Errorf("unexpected SSH signature namespace %s", sig.Namespace)
+	}
+
+	publicKey, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+	trusted := false
+	for _, key := range v.sshKeys {
+		if bytes.Equal(key.Marshal(), publicKey.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("signed with an untrusted SSH key %s", ssh.FingerprintSHA256(publicKey))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported SSH signature hash algorithm %s", sig.HashAlgorithm)
+	}
+	h.Write(payload)
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	signedData := sshSignedData{
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	}
+	copy(signedData.Magic[:], sshSignatureMagic)
+	return publicKey.Verify(ssh.Marshal(signedData), &signature)
+}
+
+// commitsBetween lists the commits reachable from the target, but not from the previous commit.
+func commitsBetween(repo *git.Repository, prev plumbing.Hash, target plumbing.Hash) ([]plumbing.Hash, error) {
+	excluded, err := reachableCommits(repo, prev, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	// The new commits can't be skipped, because they might not be signed
+	included, err := reachableCommits(repo, target, excluded, true)
+	if err != nil {
+		return nil, err
+	}
+	commits := make([]plumbing.Hash, 0, len(included))
+	for hash := range included {
+		commits = append(commits, hash)
+	}
+	return commits, nil
+}
+
+// reachableCommits lists the commits reachable from the start commit, excluding the given commits.
+// Commits missing from shallow repositories are skipped unless the complete history is required.
+func reachableCommits(
+	repo *git.Repository,
+	start plumbing.Hash,
+	excluded map[plumbing.Hash]bool,
+	complete bool,
+) (map[plumbing.Hash]bool, error) {
+	found := make(map[plumbing.Hash]bool)
+	queue := []plumbing.Hash{start}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if found[hash] || excluded[hash] {
+			continue
+		}
+		commit, err := repo.CommitObject(hash)
+		if err == plumbing.ErrObjectNotFound && !complete {
+			continue
+		}
+		if err == plumbing.ErrObjectNotFound {
+			return nil, fmt.Errorf("%w: commit %s is beyond the fetched history", errUnverifiedCommit, hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[hash] = true
+		queue = append(queue, commit.ParentHashes...)
+	}
+	return found, nil
+}
@@ -0,0 +1,289 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func writeAllowedSigners(t *testing.T, signers ...ssh.Signer) string {
+	filename := filepath.Join(t.TempDir(), "allowed_signers")
+	var content []byte
+	for _, signer := range signers {
+		content = append(content, []byte("konvahti@example.org ")...)
+		content = append(content, ssh.MarshalAuthorizedKey(signer.PublicKey())...)
+	}
+	if err := os.WriteFile(filename, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// signCommitSSH replaces the commit at the current HEAD with a commit signed using the SSH key.
+func signCommitSSH(t *testing.T, repo *git.Repository, hash plumbing.Hash, signer ssh.Signer) plumbing.Hash {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadObject := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(payloadObject); err != nil {
+		t.Fatal(err)
+	}
+	payloadReader, err := payloadObject.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := io.ReadAll(payloadReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloadHash := sha512.Sum512(payload)
+	signedData := sshSignedData{Namespace: sshSignatureNamespace, HashAlgorithm: "sha512", Hash: payloadHash[:]}
+	copy(signedData.Magic[:], sshSignatureMagic)
+	signature, err := signer.Sign(rand.Reader, ssh.Marshal(signedData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := sshSignature{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(signature),
+	}
+	copy(sig.Magic[:], sshSignatureMagic)
+	commit.PGPSignature = string(pem.EncodeToMemory(&pem.Block{Type: sshSignatureType, Bytes: ssh.Marshal(sig)}))
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	signedHash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), signedHash)); err != nil {
+		t.Fatal(err)
+	}
+	return signedHash
+}
+
+func TestVerifyCommit(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := openpgp.NewEntity("Konvahti", "", "konvahti@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	armorWriter, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(armorWriter); err != nil {
+		t.Fatal(err)
+	}
+	armorWriter.Close()
+	keyringPath := filepath.Join(t.TempDir(), "keyring.asc")
+	if err := os.WriteFile(keyringPath, keyring.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	trustedSigner := newSSHSigner(t)
+	untrustedSigner := newSSHSigner(t)
+	verifier, err := newSignatureVerifier(&GitSignatures{
+		PGPKeyringPath:        keyringPath,
+		SSHAllowedSignersPath: writeAllowedSigners(t, trustedSigner),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	verifyHash := func(hash plumbing.Hash) error {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verifier.verifyCommit(commit)
+	}
+
+	unsigned := commitFiles(t, repo, dir, map[string]string{"app.yaml": "version: 1"})
+	a.Error(verifyHash(unsigned))
+
+	a.NoError(verifyHash(signCommitSSH(t, repo, unsigned, trustedSigner)))
+	a.Error(verifyHash(signCommitSSH(t, repo, unsigned, untrustedSigner)))
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte("version: 2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("app.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	pgpSigned, err := wt.Commit("update", &git.CommitOptions{
+		Author:  &object.Signature{Name: "Konvahti", Email: "konvahti@example.org", When: time.Now()},
+		SignKey: entity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.NoError(verifyHash(pgpSigned))
+}
+
+func TestSignatureRefresh(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	signer := newSSHSigner(t)
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	signCommitSSH(t, upstream, first, signer)
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:    upstreamDir,
+		Branch: "main",
+		Signatures: GitSignatures{
+			SSHAllowedSignersPath: writeAllowedSigners(t, signer),
+			AllCommits:            true,
+		},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+
+	// Unsigned commits are not checked out even when there's a signed commit on top of them
+	commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	third := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 3"})
+	signCommitSSH(t, upstream, third, signer)
+
+	_, err = source.Refresh(ctx)
+	a.True(errors.Is(err, errUnverifiedCommit))
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+
+	fourth := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 4"})
+	signCommitSSH(t, upstream, fourth, signer)
+	source.verifier.allCommits = false
+
+	// Only the latest commit is verified by default
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml"}, changed)
+	data, err = os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 4", string(data))
+	}
+}
+
+func TestSignatureRefreshBeyondDepth(t *testing.T) {
+	requireGit(t)
+	a := assert.New(t)
+	ctx := context.Background()
+	upstreamDir := t.TempDir()
+	signer := newSSHSigner(t)
+
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+	first := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 1"})
+	signCommitSSH(t, upstream, first, signer)
+
+	var source GitSource
+	if err := source.Setup(Config{
+		URL:    upstreamDir,
+		Branch: "main",
+		Depth:  1,
+		// The fast-forward check is skipped when the history is beyond the fetched history
+		UpdateStrategy: UpdateStrategyReset,
+		Signatures: GitSignatures{
+			SSHAllowedSignersPath: writeAllowedSigners(t, signer),
+			AllCommits:            true,
+		},
+		Directory: filepath.Join(t.TempDir(), "repo"),
+	}); !a.NoError(err) {
+		return
+	}
+	if _, err := source.Refresh(ctx); !a.NoError(err) {
+		return
+	}
+
+	// The unsigned commits are deeper than the fetched history under the signed commit
+	for i := 0; i < 4; i++ {
+		commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": fmt.Sprintf("version: unsigned %d", i)})
+	}
+	tip := commitFiles(t, upstream, upstreamDir, map[string]string{"app.yaml": "version: 2"})
+	signCommitSSH(t, upstream, tip, signer)
+
+	_, err = source.Refresh(ctx)
+	a.True(errors.Is(err, errUnverifiedCommit))
+	data, err := os.ReadFile(filepath.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+type Config struct {
+	URLs      []string              `yaml:"urls"`
+	Directory string                `yaml:"directory"`
+	Auth      httpclient.AuthConfig `yaml:"auth,omitempty"`
+	TLS       httpclient.TLSConfig  `yaml:"tls,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("no HTTP URLs specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+
+	filenames := make(map[string]string, len(c.URLs))
+	for _, u := range c.URLs {
+		filename, err := urlToFilename(u)
+		if err != nil {
+			return err
+		}
+		if other, ok := filenames[filename]; ok {
+			return fmt.Errorf("URLs %s and %s map to the same file %s", other, u, filename)
+		}
+		filenames[filename] = u
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		return fmt.Errorf("invalid HTTP auth: %w", err)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config: %w", err)
+	}
+	return nil
+}
+
+// urlToFilename uses the path of the URL as the local file path.
+// For example, https://example.org/configs/app.yaml is stored to configs/app.yaml.
+func urlToFilename(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported URL scheme in %s", rawURL)
+	}
+	filename := strings.Trim(u.Path, "/")
+	if filename == "" {
+		return "", fmt.Errorf("no file path found from URL %s", rawURL)
+	}
+	for _, part := range strings.Split(filename, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("URL path must not point to parent directories: %s", rawURL)
+		}
+	}
+	return filename, nil
+}
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+var (
+	errNoChanges = errors.New("no changes found")
+)
+
+// resourceState holds the validators received with the latest download of a URL.
+// They are used for making conditional requests on the next refresh.
+// The checksum of the contents is used for detecting changes, because
+// servers are not required to support conditional requests.
+type resourceState struct {
+	etag         string
+	lastModified string
+	checksum     string
+}
+
+type HTTPSource struct {
+	fs              billy.Filesystem
+	config          Config
+	client          *http.Client
+	filenames       []string
+	states          map[string]resourceState
+	latestDirectory string
+	changes         changeset.ChangeSet
+}
+
+func (s *HTTPSource) Setup(fs billy.Filesystem, config Config) (err error) {
+	s.client, err = httpclient.New(config.TLS)
+	if err != nil {
+		return
+	}
+	s.filenames = make([]string, len(config.URLs))
+	for i, u := range config.URLs {
+		if s.filenames[i], err = urlToFilename(u); err != nil {
+			return
+		}
+	}
+	s.fs = fs
+	s.config = config
+	s.states = nil
+	s.changes = nil
+	s.latestDirectory = fs.Join(config.Directory, file.LatestLinkName)
+	return nil
+}
+
+func (s *HTTPSource) GetDirectory() string {
+	return s.latestDirectory
+}
+
+func (s *HTTPSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("refreshing files from HTTP")
+
+	nextStates := make(map[string]resourceState, len(s.config.URLs))
+	changes := make(changeset.ChangeSet, 0, len(s.config.URLs))
+	nextDirectory := s.fs.Join(s.config.Directory, file.SnapshotName())
+
+	err := file.SwapDirectory(
+		s.fs,
+		s.latestDirectory,
+		nextDirectory,
+		func(fs billy.Filesystem) error {
+			for i, u := range s.config.URLs {
+				filename := s.filenames[i]
+				state, changed, err := s.pullURL(ctx, fs, u, filename, logger)
+				if err != nil {
+					return err
+				}
+				nextStates[u] = state
+				if !changed {
+					continue
+				}
+				kind := changeset.Modified
+				if _, ok := s.states[u]; !ok {
+					kind = changeset.Added
+				}
+				changes = append(changes, changeset.Change{Kind: kind, Path: filename})
+			}
+
+			// Keep the current directory in place when nothing has changed
+			if len(changes) == 0 {
+				return errNoChanges
+			}
+			return nil
+		},
+	)
+	if errors.Is(err, errNoChanges) {
+		logger.Debug().Msg("no changes found")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.states = nextStates
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added or modified on the latest refresh.
+func (s *HTTPSource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *HTTPSource) pullURL(
+	ctx context.Context,
+	fs billy.Filesystem,
+	u string,
+	filename string,
+	logger zerolog.Logger,
+) (state resourceState, changed bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	s.config.Auth.Apply(req)
+
+	prevState, hasPrevState := s.states[u]
+	if hasPrevState {
+		if prevState.etag != "" {
+			req.Header.Set("If-None-Match", prevState.etag)
+		}
+		if prevState.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prevState.lastModified)
+		}
+	}
+
+	logger.Debug().Str("url", u).Str("filename", filename).Msg("requesting file")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close response body")
+		}
+	}()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && hasPrevState:
+		logger.Debug().Str("url", u).Str("filename", filename).Msg("copying unmodified file")
+		err = file.CopyFile(s.fs, s.fs.Join(s.latestDirectory, filename), fs, filename)
+		return prevState, false, err
+	case res.StatusCode == http.StatusOK:
+		logger.Debug().Str("url", u).Str("filename", filename).Msg("downloading file")
+		hash := sha256.New()
+		if err = file.WriteFile(fs, filename, io.TeeReader(res.Body, hash)); err != nil {
+			return
+		}
+		state = resourceState{
+			etag:         res.Header.Get("ETag"),
+			lastModified: res.Header.Get("Last-Modified"),
+			checksum:     hex.EncodeToString(hash.Sum(nil)),
+		}
+		changed = !hasPrevState || state.checksum != prevState.checksum
+		return
+	default:
+		err = fmt.Errorf("unexpected HTTP status %s from %s", res.Status, u)
+		return
+	}
+}
+
+func (s *HTTPSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	return logger.With().
+		Str("stage", "refresh").
+		Strs("httpUrls", s.config.URLs).
+		Logger()
+}
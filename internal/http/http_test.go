@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+type testServer struct {
+	contents map[string]string
+	requests int
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.requests++
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	content, ok := ts.contents[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, content)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write([]byte(content))
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	handler := &testServer{
+		contents: map[string]string{
+			"/app.yaml":        "version: 1",
+			"/configs/db.yaml": "host: localhost",
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var source HTTPSource
+	if err := source.Setup(fs, Config{
+		URLs: []string{
+			server.URL + "/app.yaml",
+			server.URL + "/configs/db.yaml",
+		},
+		Directory: "http",
+		Auth: httpclient.AuthConfig{
+			Token: "secret",
+		},
+	}); !a.NoError(err) {
+		return
+	}
+
+	// First refresh downloads everything
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml"}, changed)
+	a.ElementsMatch(changeset.FromPaths(changeset.Added, []string{"app.yaml", "configs/db.yaml"}), source.Changes())
+
+	// Nothing changed on the server
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	// One of the files changes
+	handler.contents["/app.yaml"] = "version: 2"
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal([]string{"app.yaml"}, changed)
+	a.Equal(changeset.ChangeSet{{Kind: changeset.Modified, Path: "app.yaml"}}, source.Changes())
+
+	for filename, content := range map[string]string{
+		"app.yaml":        "version: 2",
+		"configs/db.yaml": "host: localhost",
+	} {
+		data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), filename))
+		if a.NoError(err) {
+			a.Equal(content, string(data))
+		}
+	}
+	a.Equal(6, handler.requests)
+}
+
+func TestRefreshFailure(t *testing.T) {
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	server := httptest.NewServer(&testServer{})
+	defer server.Close()
+
+	var source HTTPSource
+	if err := source.Setup(fs, Config{
+		URLs:      []string{server.URL + "/missing.yaml"},
+		Directory: "http",
+	}); !assert.NoError(t, err) {
+		return
+	}
+
+	_, err := source.Refresh(context.Background())
+	assert.Error(t, err)
+}
+
+func TestURLToFilename(t *testing.T) {
+	a := assert.New(t)
+
+	for u, expected := range map[string]string{
+		"https://example.org/app.yaml":             "app.yaml",
+		"https://example.org/configs/app.yaml?x=1": "configs/app.yaml",
+		"http://example.org:8080/a/b/c/":           "a/b/c",
+	} {
+		filename, err := urlToFilename(u)
+		if a.NoError(err) {
+			a.Equal(expected, filename)
+		}
+	}
+
+	for _, u := range []string{
+		"https://example.org/",
+		"ftp://example.org/app.yaml",
+		"https://example.org/../app.yaml",
+	} {
+		_, err := urlToFilename(u)
+		a.Error(err, u)
+	}
+}
@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type AuthConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+func (c *AuthConfig) Validate() error {
+	if c.Token != "" && (c.Username != "" || c.Password != "") {
+		return fmt.Errorf("both token and basic authentication specified")
+	}
+	return nil
+}
+
+func (c *AuthConfig) Apply(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	} else if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile,omitempty"`
+	ClientCert         string `yaml:"clientCert,omitempty"`
+	ClientKey          string `yaml:"clientKey,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+}
+
+func (c *TLSConfig) Validate() error {
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("both client certificate and client key must be specified")
+	}
+	return nil
+}
+
+func (c *TLSConfig) ToTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Skipping the verification is opt-in and documented to be for testing purposes only
+		InsecureSkipVerify: c.InsecureSkipVerify, // #nosec G402
+	}
+
+	if c.CAFile != "" {
+		caPEM, err := os.ReadFile(filepath.Clean(c.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found from CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func New(tlsConfig TLSConfig) (*http.Client, error) {
+	clientTLSConfig, err := tlsConfig.ToTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = clientTLSConfig
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,267 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// Locations of the service account credentials when running inside a cluster
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// apiClient holds the connection details for a Kubernetes API server.
+type apiClient struct {
+	client    *http.Client
+	server    string
+	namespace string
+	// Token is read on each request, because service account tokens are rotated
+	tokenFunc func() (string, error)
+	username  string
+	password  string
+}
+
+func (c *apiClient) authorize(req *http.Request) error {
+	if c.tokenFunc != nil {
+		token, err := c.tokenFunc()
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			return nil
+		}
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return nil
+}
+
+func newAPIClient(config *Config) (*apiClient, error) {
+	var client *apiClient
+	var err error
+	if config.Kubeconfig != "" {
+		client, err = kubeconfigClient(config.Kubeconfig, config.Context)
+	} else {
+		client, err = inClusterClient()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.Namespace != "" {
+		client.namespace = config.Namespace
+	}
+	if client.namespace == "" {
+		client.namespace = "default"
+	}
+	return client, nil
+}
+
+func inClusterClient() (*apiClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside a Kubernetes cluster, and no kubeconfig specified")
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	tlsConfig, err := newTLSConfig(caPEM, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespace string
+	if data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	return &apiClient{
+		client:    newHTTPClient(tlsConfig),
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		tokenFunc: tokenFileFunc(filepath.Join(serviceAccountDir, "token")),
+	}, nil
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Username              string      `yaml:"username"`
+			Password              string      `yaml:"password"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+func kubeconfigClient(filename string, contextName string) (*apiClient, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %w", filename, err)
+	}
+	baseDir := filepath.Dir(filename)
+
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	contextIndex := -1
+	for i := range kc.Contexts {
+		if kc.Contexts[i].Name == contextName {
+			contextIndex = i
+		}
+	}
+	if contextIndex < 0 {
+		return nil, fmt.Errorf("context %s not found from kubeconfig", contextName)
+	}
+	kubeContext := kc.Contexts[contextIndex].Context
+
+	client := &apiClient{namespace: kubeContext.Namespace}
+	var caPEM, certPEM, keyPEM []byte
+	var insecure bool
+	clusterFound := false
+	for _, cluster := range kc.Clusters {
+		if cluster.Name != kubeContext.Cluster {
+			continue
+		}
+		clusterFound = true
+		client.server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		insecure = cluster.Cluster.InsecureSkipTLSVerify
+		var err error
+		if caPEM, err = readInlineOrFile(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority, baseDir); err != nil {
+			return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+		}
+	}
+	if !clusterFound {
+		return nil, fmt.Errorf("cluster %s not found from kubeconfig", kubeContext.Cluster)
+	}
+
+	for _, user := range kc.Users {
+		if user.Name != kubeContext.User {
+			continue
+		}
+		if user.User.Exec != nil || user.User.AuthProvider != nil {
+			return nil, fmt.Errorf("kubeconfig user %s uses an unsupported authentication plugin", user.Name)
+		}
+		var err error
+		if certPEM, err = readInlineOrFile(user.User.ClientCertificateData, user.User.ClientCertificate, baseDir); err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %w", err)
+		}
+		if keyPEM, err = readInlineOrFile(user.User.ClientKeyData, user.User.ClientKey, baseDir); err != nil {
+			return nil, fmt.Errorf("failed to read client key: %w", err)
+		}
+		if user.User.Token != "" {
+			token := user.User.Token
+			client.tokenFunc = func() (string, error) { return token, nil }
+		} else if user.User.TokenFile != "" {
+			client.tokenFunc = tokenFileFunc(resolvePath(user.User.TokenFile, baseDir))
+		}
+		client.username = user.User.Username
+		client.password = user.User.Password
+	}
+
+	tlsConfig, err := newTLSConfig(caPEM, certPEM, keyPEM, insecure)
+	if err != nil {
+		return nil, err
+	}
+	client.client = newHTTPClient(tlsConfig)
+	return client, nil
+}
+
+// readInlineOrFile reads the base64 encoded inline data, or the contents of the file when no inline data is given.
+func readInlineOrFile(data string, filename string, baseDir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if filename != "" {
+		return os.ReadFile(resolvePath(filename, baseDir))
+	}
+	return nil, nil
+}
+
+// resolvePath resolves paths relative to the kubeconfig file just like kubectl does.
+func resolvePath(filename string, baseDir string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(baseDir, filename)
+}
+
+func tokenFileFunc(filename string) func() (string, error) {
+	return func() (string, error) {
+		data, err := os.ReadFile(filepath.Clean(filename))
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+}
+
+func newTLSConfig(caPEM, certPEM, keyPEM []byte, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Skipping the verification is opt-in via kubeconfig
+		InsecureSkipVerify: insecure, // #nosec G402
+	}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found from cluster CA")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}
+}
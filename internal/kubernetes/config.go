@@ -0,0 +1,44 @@
+package kubernetes
+
+import (
+	"fmt"
+)
+
+const (
+	KindConfigMap = "ConfigMap"
+	KindSecret    = "Secret"
+)
+
+type Config struct {
+	Kind          string `yaml:"kind,omitempty"`
+	Namespace     string `yaml:"namespace,omitempty"`
+	LabelSelector string `yaml:"labelSelector,omitempty"`
+	Directory     string `yaml:"directory"`
+	Kubeconfig    string `yaml:"kubeconfig,omitempty"`
+	Context       string `yaml:"context,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if _, err := c.resource(); err != nil {
+		return err
+	}
+	if c.Context != "" && c.Kubeconfig == "" {
+		return fmt.Errorf("kubeconfig context specified without a kubeconfig file")
+	}
+	return nil
+}
+
+// resource returns the API resource name for the kind.
+func (c *Config) resource() (string, error) {
+	switch c.Kind {
+	case "", KindConfigMap:
+		return "configmaps", nil
+	case KindSecret:
+		return "secrets", nil
+	default:
+		return "", fmt.Errorf("unsupported Kubernetes kind %s", c.Kind)
+	}
+}
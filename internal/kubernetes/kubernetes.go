@@ -0,0 +1,273 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/blob"
+	"gitlab.com/lepovirta/konvahti/internal/retry"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+var (
+	watchRetryStrat = retry.ExponentialBackoff(time.Second, time.Minute)
+)
+
+// KubernetesSource synchronizes the keys of ConfigMaps or Secrets to files.
+// Each key is stored to a file named OBJECTNAME/KEY.
+type KubernetesSource struct {
+	blob.Source
+	store *store
+}
+
+func (s *KubernetesSource) Setup(fs billy.Filesystem, config Config) error {
+	resource, err := config.resource()
+	if err != nil {
+		return err
+	}
+	client, err := newAPIClient(&config)
+	if err != nil {
+		return err
+	}
+	s.store = &store{
+		client:   client,
+		resource: resource,
+		config:   config,
+	}
+	s.Source.Setup(fs, s.store, config.Directory)
+	if config.Kind == KindSecret {
+		// Secret values are only readable by the user running konvahti
+		s.SetFileMode(0600)
+	}
+	return nil
+}
+
+// Notifications uses a Kubernetes watch to find out when the objects change.
+func (s *KubernetesSource) Notifications(ctx context.Context) <-chan struct{} {
+	logger := s.store.LogContext(zerolog.Ctx(ctx).With().Str("stage", "watch")).Logger()
+	notifyCh := make(chan struct{}, 1)
+	go s.store.watch(ctx, notifyCh, logger)
+	return notifyCh
+}
+
+type store struct {
+	client   *apiClient
+	resource string
+	config   Config
+
+	blob.Values
+}
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type object struct {
+	Metadata   objectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binaryData"`
+}
+
+type objectList struct {
+	Metadata objectMeta `json:"metadata"`
+	Items    []object   `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string `json:"type"`
+	Object struct {
+		Metadata objectMeta `json:"metadata"`
+		// Only set for error events
+		Code int `json:"code"`
+	} `json:"object"`
+}
+
+func (s *store) List(ctx context.Context) (stat.Versions, error) {
+	list, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(stat.Versions)
+	values := make(map[string][]byte)
+	for _, item := range list.Items {
+		itemValues, err := s.objectValues(&item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s: %w", s.config.Kind, item.Metadata.Name, err)
+		}
+		for key, value := range itemValues {
+			filename, ok := blob.KeyToFilename("", item.Metadata.Name+"/"+key)
+			if !ok {
+				continue
+			}
+			sum := sha256.Sum256(value)
+			versions[filename] = hex.EncodeToString(sum[:])
+			values[filename] = value
+		}
+	}
+
+	s.Set(values)
+	return versions, nil
+}
+
+// objectValues decodes the values of the object. Secret values are base64 encoded.
+func (s *store) objectValues(item *object) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(item.Data)+len(item.BinaryData))
+	for key, value := range item.BinaryData {
+		values[key] = value
+	}
+	for key, value := range item.Data {
+		if s.resource == "secrets" {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = decoded
+		} else {
+			values[key] = []byte(value)
+		}
+	}
+	return values, nil
+}
+
+func (s *store) list(ctx context.Context) (*objectList, error) {
+	res, err := s.get(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var list objectList
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (s *store) watch(ctx context.Context, notifyCh chan<- struct{}, logger zerolog.Logger) {
+	var resourceVersion string
+	failures := 0
+	for ctx.Err() == nil {
+		var err error
+		resourceVersion, err = s.watchStream(ctx, resourceVersion, notifyCh, logger)
+		if err == nil {
+			// The API server closes watches periodically, so they can be restarted right away
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		delay := watchRetryStrat(failures)
+		failures++
+		logger.Warn().Err(err).Str("nextAttemptIn", delay.String()).Msg("watch failed")
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watchStream watches the objects for changes after the given resource version,
+// and returns the latest resource version seen.
+func (s *store) watchStream(
+	ctx context.Context,
+	resourceVersion string,
+	notifyCh chan<- struct{},
+	logger zerolog.Logger,
+) (string, error) {
+	if resourceVersion == "" {
+		// Start from the current state of the objects
+		list, err := s.list(ctx)
+		if err != nil {
+			return "", err
+		}
+		resourceVersion = list.Metadata.ResourceVersion
+	}
+
+	res, err := s.get(ctx, url.Values{
+		"watch":               []string{"true"},
+		"resourceVersion":     []string{resourceVersion},
+		"allowWatchBookmarks": []string{"true"},
+	})
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err == io.EOF {
+			return resourceVersion, nil
+		} else if err != nil {
+			return resourceVersion, err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			logger.Debug().
+				Str("eventType", event.Type).
+				Str("objectName", event.Object.Metadata.Name).
+				Msg("object changed")
+			blob.Notify(notifyCh)
+			resourceVersion = event.Object.Metadata.ResourceVersion
+		case "BOOKMARK":
+			resourceVersion = event.Object.Metadata.ResourceVersion
+		case "ERROR":
+			if event.Object.Code == http.StatusGone {
+				// The resource version is too old, so changes may have been missed
+				blob.Notify(notifyCh)
+				return "", nil
+			}
+			return resourceVersion, fmt.Errorf("watch error with code %d", event.Object.Code)
+		}
+	}
+}
+
+func (s *store) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	if s.config.LabelSelector != "" {
+		query.Set("labelSelector", s.config.LabelSelector)
+	}
+	reqURL := fmt.Sprintf(
+		"%s/api/v1/namespaces/%s/%s?%s",
+		s.client.server, url.PathEscape(s.client.namespace), s.resource, query.Encode(),
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := s.client.authorize(req); err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s from Kubernetes API", res.Status)
+	}
+	return res, nil
+}
+
+func (s *store) LogContext(logCtx zerolog.Context) zerolog.Context {
+	return logCtx.
+		Str("k8sServer", s.client.server).
+		Str("k8sNamespace", s.client.namespace).
+		Str("k8sResource", s.resource).
+		Str("k8sLabelSelector", s.config.LabelSelector)
+}
@@ -0,0 +1,290 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+type fakeEvent struct {
+	resourceVersion int
+	eventType       string
+	object          map[string]interface{}
+}
+
+// fakeAPIServer serves list and watch requests for the ConfigMaps in the namespace "konvahti".
+type fakeAPIServer struct {
+	mutex           sync.Mutex
+	changed         chan struct{}
+	resourceVersion int
+	objects         map[string]map[string]interface{}
+	labels          map[string]string
+	events          []fakeEvent
+}
+
+func newFakeAPIServer() *fakeAPIServer {
+	return &fakeAPIServer{
+		changed: make(chan struct{}),
+		objects: map[string]map[string]interface{}{},
+		labels:  map[string]string{},
+	}
+}
+
+func (s *fakeAPIServer) put(name, label string, data map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resourceVersion++
+	eventType := "MODIFIED"
+	if _, ok := s.objects[name]; !ok {
+		eventType = "ADDED"
+	}
+	obj := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"resourceVersion": strconv.Itoa(s.resourceVersion),
+		},
+		"data": data,
+	}
+	s.objects[name] = obj
+	s.labels[name] = label
+	s.events = append(s.events, fakeEvent{s.resourceVersion, eventType, obj})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/api/v1/namespaces/konvahti/configmaps" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	selector := query.Get("labelSelector")
+	if query.Get("watch") == "true" {
+		resourceVersion, _ := strconv.Atoi(query.Get("resourceVersion"))
+		s.serveWatch(w, r, selector, resourceVersion)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	items := []map[string]interface{}{}
+	for name, obj := range s.objects {
+		if selector == "" || s.labels[name] == selector {
+			items = append(items, obj)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": strconv.Itoa(s.resourceVersion)},
+		"items":    items,
+	})
+}
+
+func (s *fakeAPIServer) serveWatch(w http.ResponseWriter, r *http.Request, selector string, resourceVersion int) {
+	encoder := json.NewEncoder(w)
+	for {
+		s.mutex.Lock()
+		for _, event := range s.events {
+			name := event.object["metadata"].(map[string]interface{})["name"].(string)
+			if event.resourceVersion > resourceVersion && (selector == "" || s.labels[name] == selector) {
+				_ = encoder.Encode(map[string]interface{}{"type": event.eventType, "object": event.object})
+			}
+		}
+		resourceVersion = s.resourceVersion
+		changed := s.changed
+		s.mutex.Unlock()
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func writeKubeconfig(t *testing.T, server string) string {
+	filename := filepath.Join(t.TempDir(), "kubeconfig")
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+users:
+- name: test
+  user:
+    tokenFile: token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: konvahti
+`, server)
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(filename), "token"), []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	apiServer := newFakeAPIServer()
+	apiServer.put("app", "app=konvahti", map[string]string{"app.yaml": "version: 1", "db.yaml": "host: localhost"})
+	apiServer.put("other", "app=other", map[string]string{"other.yaml": "other: true"})
+	server := httptest.NewServer(apiServer)
+	defer func() {
+		cancel()
+		server.Close()
+	}()
+
+	var source KubernetesSource
+	if err := source.Setup(fs, Config{
+		Kind:          KindConfigMap,
+		LabelSelector: "app=konvahti",
+		Directory:     "kubernetes",
+		Kubeconfig:    writeKubeconfig(t, server.URL),
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app/app.yaml", "app/db.yaml"}, changed)
+
+	notifications := source.Notifications(log.Logger.WithContext(ctx))
+	// Give the watch time to establish the starting resource version
+	time.Sleep(100 * time.Millisecond)
+	apiServer.put("other", "app=other", map[string]string{"other.yaml": "other: false"})
+	apiServer.put("app", "app=konvahti", map[string]string{"app.yaml": "version: 2"})
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		a.Fail("no notification received")
+		return
+	}
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app/app.yaml", "app/db.yaml"}, changed)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "app/app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "app/db.yaml"))
+	a.Error(err)
+}
+
+func TestInClusterSecrets(t *testing.T) {
+	a := assert.New(t)
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" || r.URL.Path != "/api/v1/namespaces/apps/secrets" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": "1"},
+			"items": []map[string]interface{}{{
+				"metadata": map[string]interface{}{"name": "credentials"},
+				"data": map[string]string{
+					"password": base64.StdEncoding.EncodeToString([]byte("hunter2")),
+				},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	saDir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	for filename, content := range map[string][]byte{
+		"ca.crt":    caPEM,
+		"namespace": []byte("apps"),
+		"token":     []byte("sa-token"),
+	} {
+		if err := os.WriteFile(filepath.Join(saDir, filename), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	origServiceAccountDir := serviceAccountDir
+	serviceAccountDir = saDir
+	defer func() { serviceAccountDir = origServiceAccountDir }()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+
+	var source KubernetesSource
+	if err := source.Setup(fs, Config{
+		Kind:      KindSecret,
+		Directory: "secrets",
+	}); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(context.Background())
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"credentials/password"}, changed)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "credentials/password"))
+	if a.NoError(err) {
+		a.Equal("hunter2", string(data))
+	}
+	info, err := fs.Stat(fs.Join(source.GetDirectory(), "credentials/password"))
+	if a.NoError(err) {
+		a.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+}
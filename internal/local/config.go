@@ -0,0 +1,17 @@
+package local
+
+import (
+	"fmt"
+)
+
+type Config struct {
+	Directory            string `yaml:"directory"`
+	DisableNotifications bool   `yaml:"disableNotifications,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	return nil
+}
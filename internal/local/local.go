@@ -0,0 +1,149 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+)
+
+// fileState is used for detecting file changes.
+// The hash is only recalculated when the size or the modification time changes.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+type LocalSource struct {
+	fs      billy.Filesystem
+	config  Config
+	states  map[string]fileState
+	changes changeset.ChangeSet
+}
+
+func (s *LocalSource) Setup(fs billy.Filesystem, config Config) error {
+	s.fs = fs
+	s.config = config
+	s.states = nil
+	s.changes = nil
+	return nil
+}
+
+func (s *LocalSource) GetDirectory() string {
+	return s.config.Directory
+}
+
+func (s *LocalSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("scanning files from local directory")
+
+	nextStates := make(map[string]fileState, len(s.states))
+	if err := s.scan("", nextStates); err != nil {
+		return nil, err
+	}
+
+	changes := make(changeset.ChangeSet, 0, len(nextStates))
+	for filename, state := range nextStates {
+		if prevState, ok := s.states[filename]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Added, Path: filename})
+		} else if prevState.hash != state.hash {
+			changes = append(changes, changeset.Change{Kind: changeset.Modified, Path: filename})
+		}
+	}
+	for filename := range s.states {
+		if _, ok := nextStates[filename]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Deleted, Path: filename})
+		}
+	}
+
+	s.states = nextStates
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *LocalSource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *LocalSource) scan(relPath string, nextStates map[string]fileState) error {
+	entries, err := s.fs.ReadDir(s.fs.Join(s.config.Directory, relPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := path.Join(relPath, entry.Name())
+		switch {
+		case entry.IsDir():
+			if err := s.scan(entryPath, nextStates); err != nil {
+				return err
+			}
+		case entry.Mode().IsRegular():
+			state, err := s.fileState(entryPath, entry)
+			if os.IsNotExist(err) {
+				// File was removed during the scan
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			nextStates[entryPath] = state
+		}
+	}
+	return nil
+}
+
+func (s *LocalSource) fileState(filename string, info os.FileInfo) (fileState, error) {
+	state := fileState{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	if prevState, ok := s.states[filename]; ok &&
+		prevState.size == state.size &&
+		prevState.modTime.Equal(state.modTime) {
+		state.hash = prevState.hash
+		return state, nil
+	}
+
+	hash, err := file.HashFile(s.fs, s.fs.Join(s.config.Directory, filename))
+	state.hash = hash
+	return state, err
+}
+
+// Notifications provides a channel that receives a value whenever files change
+// in the local directory. When notifications are not available, nil is returned,
+// and the changes are only detected by scanning the directory on each refresh.
+func (s *LocalSource) Notifications(ctx context.Context) <-chan struct{} {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	if s.config.DisableNotifications {
+		logger.Debug().Msg("file system notifications disabled")
+		return nil
+	}
+
+	notifyCh := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case notifyCh <- struct{}{}:
+		default:
+			// Notification already pending
+		}
+	}
+	if err := watchDirectory(ctx, s.config.Directory, notify, logger); err != nil {
+		logger.Warn().Err(err).Msg("file system notifications not available, falling back to scanning")
+		return nil
+	}
+	return notifyCh
+}
+
+func (s *LocalSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	return logger.With().
+		Str("stage", "refresh").
+		Str("localDirectory", s.config.Directory).
+		Logger()
+}
@@ -0,0 +1,152 @@
+package local
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	if err := util.WriteFile(fs, "watched/app.yaml", []byte("version: 1"), 0660); !a.NoError(err) {
+		return
+	}
+	if err := util.WriteFile(fs, "watched/configs/db.yaml", []byte("host: localhost"), 0660); !a.NoError(err) {
+		return
+	}
+
+	var source LocalSource
+	if err := source.Setup(fs, Config{Directory: "watched"}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml"}, changed)
+	a.ElementsMatch(changeset.FromPaths(changeset.Added, []string{"app.yaml", "configs/db.yaml"}), source.Changes())
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	if err := util.WriteFile(fs, "watched/app.yaml", []byte("version: 2"), 0660); !a.NoError(err) {
+		return
+	}
+	if err := fs.Remove("watched/configs/db.yaml"); !a.NoError(err) {
+		return
+	}
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "configs/db.yaml"},
+	}, source.Changes())
+}
+
+func TestNotifications(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := osfs.New("")
+	defer func() {
+		if err := util.RemoveAll(fs, testDataDir); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+	directory := fs.Join(testDataDir, "watched")
+	if err := fs.MkdirAll(directory, 0750); !a.NoError(err) {
+		return
+	}
+
+	var source LocalSource
+	if err := source.Setup(fs, Config{Directory: directory}); !a.NoError(err) {
+		return
+	}
+	notifications := source.Notifications(ctx)
+	if notifications == nil {
+		t.Skip("file system notifications not supported")
+	}
+
+	// Files in new sub-directories are also watched
+	if err := fs.MkdirAll(fs.Join(directory, "configs"), 0750); !a.NoError(err) {
+		return
+	}
+	if !receiveNotification(a, notifications) {
+		return
+	}
+	// Give the watcher a moment to start watching the new directory
+	time.Sleep(100 * time.Millisecond)
+	drainNotifications(notifications)
+
+	if err := util.WriteFile(fs, fs.Join(directory, "configs", "db.yaml"), []byte("host: localhost"), 0660); !a.NoError(err) {
+		return
+	}
+	if !receiveNotification(a, notifications) {
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	drainNotifications(notifications)
+
+	// Files are reported once they have been written completely
+	f, err := fs.Create(fs.Join(directory, "app.yaml"))
+	if !a.NoError(err) {
+		return
+	}
+	if _, err := f.Write([]byte("version: 1")); !a.NoError(err) {
+		f.Close()
+		return
+	}
+	select {
+	case <-notifications:
+		a.Fail("notification received before the file was closed")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if !a.NoError(f.Close()) {
+		return
+	}
+	receiveNotification(a, notifications)
+}
+
+func receiveNotification(a *assert.Assertions, notifications <-chan struct{}) bool {
+	select {
+	case <-notifications:
+		return true
+	case <-time.After(5 * time.Second):
+		return a.Fail("no notification received")
+	}
+}
+
+func drainNotifications(notifications <-chan struct{}) {
+	for {
+		select {
+		case <-notifications:
+		default:
+			return
+		}
+	}
+}
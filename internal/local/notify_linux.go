@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package local
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Files are reported once they have been written and closed instead of on each write,
+	// so that the files are not scanned while they are still being written.
+	inotifyMask = unix.IN_CREATE |
+		unix.IN_CLOSE_WRITE |
+		unix.IN_DELETE |
+		unix.IN_DELETE_SELF |
+		unix.IN_MOVED_FROM |
+		unix.IN_MOVED_TO
+	// How often the context is checked for cancellation while waiting for events
+	pollTimeoutMs = 500
+)
+
+type inotifyWatcher struct {
+	fd          int
+	directories map[int]string
+	logger      zerolog.Logger
+}
+
+func watchDirectory(
+	ctx context.Context,
+	directory string,
+	notify func(),
+	logger zerolog.Logger,
+) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	w := &inotifyWatcher{
+		fd:          fd,
+		directories: make(map[int]string),
+		logger:      logger,
+	}
+	if err := w.addRecursive(directory); err != nil {
+		w.close()
+		return err
+	}
+
+	go func() {
+		defer w.close()
+		if err := w.run(ctx, notify); err != nil {
+			logger.Error().Err(err).Msg("file system notifications stopped")
+		}
+	}()
+	return nil
+}
+
+func (w *inotifyWatcher) addRecursive(directory string) error {
+	return filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.directories[wd] = path
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) run(ctx context.Context, notify func()) error {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	pollFds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		n, err := unix.Poll(pollFds, pollTimeoutMs)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err = unix.Read(w.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if w.handleEvents(buf[:n]) {
+			notify()
+		}
+	}
+}
+
+// handleEvents starts watching new sub-directories, and reports whether
+// any of the events should trigger a refresh.
+func (w *inotifyWatcher) handleEvents(buf []byte) (changed bool) {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+		offset += unix.SizeofInotifyEvent + int(event.Len)
+
+		switch {
+		case event.Mask&unix.IN_Q_OVERFLOW != 0:
+			w.logger.Debug().Msg("file system notification queue overflowed")
+		case event.Mask&unix.IN_IGNORED != 0:
+			delete(w.directories, int(event.Wd))
+			continue
+		case event.Mask&unix.IN_ISDIR == 0 && event.Mask&unix.IN_CREATE != 0:
+			// New files are reported once they have been closed
+			continue
+		case event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			name := string(nameBytes[:clen(nameBytes)])
+			if parent, ok := w.directories[int(event.Wd)]; ok {
+				if err := w.addRecursive(filepath.Join(parent, name)); err != nil {
+					w.logger.Warn().Err(err).Str("directory", name).Msg("failed to watch new directory")
+				}
+			}
+		}
+		changed = true
+	}
+	return
+}
+
+func (w *inotifyWatcher) close() {
+	if err := unix.Close(w.fd); err != nil {
+		w.logger.Error().Err(err).Msg("failed to close file system notifications")
+	}
+}
+
+func clen(b []byte) int {
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package local
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+func watchDirectory(
+	ctx context.Context,
+	directory string,
+	notify func(),
+	logger zerolog.Logger,
+) error {
+	return fmt.Errorf("file system notifications are not supported on this platform")
+}
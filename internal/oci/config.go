@@ -0,0 +1,77 @@
+package oci
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	defaultTag = "latest"
+)
+
+type Config struct {
+	Registry   string                `yaml:"registry"`
+	Repository string                `yaml:"repository"`
+	Tag        string                `yaml:"tag,omitempty"`
+	Directory  string                `yaml:"directory"`
+	Auth       httpclient.AuthConfig `yaml:"auth,omitempty"`
+	TLS        httpclient.TLSConfig  `yaml:"tls,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Registry == "" {
+		return fmt.Errorf("no OCI registry specified")
+	}
+	if _, err := c.registryURL(); err != nil {
+		return err
+	}
+	if c.Repository == "" {
+		return fmt.Errorf("no OCI repository specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if err := c.Auth.Validate(); err != nil {
+		return fmt.Errorf("invalid registry auth: %w", err)
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config: %w", err)
+	}
+	return nil
+}
+
+// registryURL returns the base URL of the registry API.
+// HTTPS is used when the registry is specified without a scheme.
+func (c *Config) registryURL() (string, error) {
+	registry := c.Registry
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	u, err := url.Parse(registry)
+	if err != nil {
+		return "", fmt.Errorf("invalid OCI registry %s: %w", c.Registry, err)
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid OCI registry %s", c.Registry)
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+}
+
+func (c *Config) tag() string {
+	if c.Tag == "" {
+		return defaultTag
+	}
+	return c.Tag
+}
+
+// reference returns the artifact reference in the usual REGISTRY/REPOSITORY:TAG format.
+func (c *Config) reference() string {
+	host := c.Registry
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	return fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(host, "/"), c.Repository, c.tag())
+}
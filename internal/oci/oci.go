@@ -0,0 +1,221 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/archive"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+)
+
+const (
+	digestEnvKey    = "KONVAHTI_OCI_DIGEST"
+	referenceEnvKey = "KONVAHTI_OCI_REFERENCE"
+
+	// Annotations used by ORAS for storing files and directories as layers
+	titleAnnotation  = "org.opencontainers.image.title"
+	unpackAnnotation = "io.deis.oras.content.unpack"
+)
+
+var (
+	errNoChanges = errors.New("no changes found")
+)
+
+type OCISource struct {
+	fs              billy.Filesystem
+	config          Config
+	registry        *registryClient
+	digest          string
+	hashes          stat.Versions
+	latestDirectory string
+	changes         changeset.ChangeSet
+}
+
+func (s *OCISource) Setup(fs billy.Filesystem, config Config) (err error) {
+	if s.registry, err = newRegistryClient(&config); err != nil {
+		return
+	}
+	s.fs = fs
+	s.config = config
+	s.digest = ""
+	s.hashes = nil
+	s.changes = nil
+	s.latestDirectory = fs.Join(config.Directory, file.LatestLinkName)
+	return nil
+}
+
+func (s *OCISource) GetDirectory() string {
+	return s.latestDirectory
+}
+
+// EnvVars exposes the artifact reference and the manifest digest of the latest snapshot.
+func (s *OCISource) EnvVars() envvars.EnvVars {
+	if s.digest == "" {
+		return nil
+	}
+	return envvars.FromKeyValue(referenceEnvKey, s.config.reference()).
+		Add(digestEnvKey, s.digest)
+}
+
+func (s *OCISource) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("refreshing files from OCI registry")
+
+	// The hashes of the previous snapshot are only calculated from the file system
+	// when they are not available in memory (e.g. after a reboot).
+	if s.hashes == nil {
+		hashes, err := file.HashDirectory(s.fs, s.latestDirectory)
+		if err != nil {
+			return nil, err
+		}
+		s.hashes = hashes
+	}
+
+	digest, err := s.registry.resolveDigest(ctx, s.config.tag())
+	if err != nil {
+		return nil, err
+	}
+	if digest != "" && digest == s.digest {
+		logger.Debug().Str("ociDigest", digest).Msg("digest not changed")
+		return nil, nil
+	}
+
+	m, digest, err := s.registry.manifest(ctx, s.config.tag())
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With().Str("ociDigest", digest).Logger()
+	if digest == s.digest {
+		logger.Debug().Msg("digest not changed")
+		return nil, nil
+	}
+
+	var changes changeset.ChangeSet
+	var nextHashes stat.Versions
+	nextDirectory := s.fs.Join(s.config.Directory, file.SnapshotName())
+	err = file.SwapDirectory(
+		s.fs,
+		s.latestDirectory,
+		nextDirectory,
+		func(fs billy.Filesystem) error {
+			hashes := make(stat.Versions)
+			for _, layer := range m.Layers {
+				logger.Debug().
+					Str("layerDigest", layer.Digest).
+					Str("layerMediaType", layer.MediaType).
+					Msg("pulling layer")
+				if err := s.pullLayer(ctx, fs, layer, hashes); err != nil {
+					return fmt.Errorf("failed to pull layer %s: %w", layer.Digest, err)
+				}
+			}
+			changes = s.hashes.Changes(hashes)
+			nextHashes = hashes
+
+			// Keep the current directory in place when the contents haven't changed
+			if len(changes) == 0 {
+				return errNoChanges
+			}
+			return nil
+		},
+	)
+	if errors.Is(err, errNoChanges) {
+		logger.Debug().Msg("no changes found")
+		s.digest = digest
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.digest = digest
+	s.hashes = nextHashes
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *OCISource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *OCISource) pullLayer(
+	ctx context.Context,
+	fs billy.Filesystem,
+	layer descriptor,
+	hashes stat.Versions,
+) error {
+	blob, err := s.registry.blob(ctx, layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	title := layer.Annotations[titleAnnotation]
+	var layerHashes stat.Versions
+	switch {
+	case title != "" && layer.Annotations[unpackAnnotation] == "true":
+		// Directory packed by ORAS
+		var directory string
+		if directory, err = archive.SanitizeEntryName(title); err != nil {
+			return err
+		}
+		var directoryFs billy.Filesystem
+		if directoryFs, err = fs.Chroot(directory); err != nil {
+			return err
+		}
+		if layerHashes, err = unpackLayer(directoryFs, layer.MediaType, blob); err == nil {
+			layerHashes = prefixed(directory, layerHashes)
+		}
+	case title != "":
+		// Single file pushed by ORAS
+		layerHashes = make(stat.Versions)
+		err = archive.WriteEntry(fs, title, blob, layerHashes)
+	default:
+		layerHashes, err = unpackLayer(fs, layer.MediaType, blob)
+	}
+	if err != nil {
+		return err
+	}
+	if err := blob.Verify(); err != nil {
+		return err
+	}
+
+	// Files in later layers override the files in earlier layers
+	for filename, hash := range layerHashes {
+		hashes[filename] = hash
+	}
+	return nil
+}
+
+func unpackLayer(fs billy.Filesystem, mediaType string, blob *digestReader) (stat.Versions, error) {
+	switch {
+	case strings.HasSuffix(mediaType, "tar+gzip") || strings.HasSuffix(mediaType, "tar.gzip"):
+		return archive.UnpackTarGz(fs, blob)
+	case strings.HasSuffix(mediaType, ".tar"):
+		return archive.UnpackTar(fs, blob)
+	default:
+		return nil, fmt.Errorf("unsupported layer media type %s", mediaType)
+	}
+}
+
+func prefixed(prefix string, hashes stat.Versions) stat.Versions {
+	result := make(stat.Versions, len(hashes))
+	for filename, hash := range hashes {
+		result[prefix+"/"+filename] = hash
+	}
+	return result
+}
+
+func (s *OCISource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	return logger.With().
+		Str("stage", "refresh").
+		Str("ociReference", s.config.reference()).
+		Logger()
+}
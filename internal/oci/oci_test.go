@@ -0,0 +1,272 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	testDataDir    = "_testdata"
+	testRepository = "team/configs"
+	testToken      = "registrytoken"
+)
+
+// fakeRegistry serves manifests and blobs for a single repository.
+// All registry requests require a bearer token from the token endpoint.
+type fakeRegistry struct {
+	t             *testing.T
+	manifests     map[string][]byte
+	blobs         map[string][]byte
+	manifestFetch int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	return &fakeRegistry{
+		t:         t,
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+	}
+}
+
+func (r *fakeRegistry) addBlob(content []byte) string {
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[digest] = content
+	return digest
+}
+
+func (r *fakeRegistry) tag(tag string, layers ...descriptor) {
+	m := manifest{
+		MediaType: mediaTypeOCIManifest,
+		Config: descriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    r.addBlob([]byte("{}")),
+			Size:      2,
+		},
+		Layers: layers,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.manifests[tag] = data
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if username, password, _ := req.BasicAuth(); username != "konvahti" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="http://%s/token",service="fake",scope="repository:%s:pull"`,
+			req.Host, testRepository,
+		))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	manifestPrefix := fmt.Sprintf("/v2/%s/manifests/", testRepository)
+	blobPrefix := fmt.Sprintf("/v2/%s/blobs/", testRepository)
+	switch {
+	case strings.HasPrefix(req.URL.Path, manifestPrefix):
+		data, ok := r.manifests[strings.TrimPrefix(req.URL.Path, manifestPrefix)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sum := sha256.Sum256(data)
+		w.Header().Set("Content-Type", mediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+		if req.Method == http.MethodGet {
+			r.manifestFetch++
+			_, _ = w.Write(data)
+		}
+	case strings.HasPrefix(req.URL.Path, blobPrefix):
+		data, ok := r.blobs[strings.TrimPrefix(req.URL.Path, blobPrefix)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func tarGzLayer(t *testing.T, r *fakeRegistry, files map[string]string) descriptor {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return descriptor{
+		MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+		Digest:    r.addBlob(buf.Bytes()),
+		Size:      int64(buf.Len()),
+	}
+}
+
+func fileLayer(r *fakeRegistry, name, content string) descriptor {
+	return descriptor{
+		MediaType:   "application/vnd.oci.image.layer.v1.tar",
+		Digest:      r.addBlob([]byte(content)),
+		Size:        int64(len(content)),
+		Annotations: map[string]string{titleAnnotation: name},
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	registry := newFakeRegistry(t)
+	registry.tag(
+		"v1",
+		tarGzLayer(t, registry, map[string]string{
+			"app.yaml":    "version: 1",
+			"sub/db.yaml": "host: localhost",
+		}),
+		fileLayer(registry, "extra.txt", "extra"),
+	)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	var source OCISource
+	if err := source.Setup(fs, Config{
+		Registry:   server.URL,
+		Repository: testRepository,
+		Tag:        "v1",
+		Directory:  "oci",
+		Auth: httpclient.AuthConfig{
+			Username: "konvahti",
+			Password: "secret",
+		},
+	}); !a.NoError(err) {
+		return
+	}
+	a.Nil(source.EnvVars())
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "sub/db.yaml", "extra.txt"}, changed)
+	digest, ok := source.EnvVars().Lookup(digestEnvKey)
+	a.True(ok)
+	a.True(strings.HasPrefix(digest, "sha256:"))
+
+	// Unchanged digest is detected without downloading the manifest again
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+	a.Equal(1, registry.manifestFetch)
+
+	registry.tag(
+		"v1",
+		tarGzLayer(t, registry, map[string]string{
+			"app.yaml":    "version: 2",
+			"sub/db.yaml": "host: localhost",
+		}),
+	)
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "extra.txt"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "extra.txt"},
+	}, source.Changes())
+	nextDigest, _ := source.EnvVars().Lookup(digestEnvKey)
+	a.NotEqual(digest, nextDigest)
+
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+	_, err = fs.Stat(fs.Join(source.GetDirectory(), "extra.txt"))
+	a.Error(err)
+}
+
+func TestRefreshDigestMismatch(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	registry := newFakeRegistry(t)
+	layer := fileLayer(registry, "app.yaml", "version: 1")
+	registry.blobs[layer.Digest] = []byte("tampered")
+	registry.tag("latest", layer)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	var source OCISource
+	if err := source.Setup(fs, Config{
+		Registry:   server.URL,
+		Repository: testRepository,
+		Directory:  "oci",
+		Auth: httpclient.AuthConfig{
+			Username: "konvahti",
+			Password: "secret",
+		},
+	}); !a.NoError(err) {
+		return
+	}
+
+	_, err := source.Refresh(ctx)
+	a.Error(err)
+	a.Equal(envvars.EnvVars(nil), source.EnvVars())
+}
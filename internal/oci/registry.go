@@ -0,0 +1,293 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gitlab.com/lepovirta/konvahti/internal/httpclient"
+)
+
+const (
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// Manifests are small JSON documents, so anything larger is treated as an error.
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var (
+	challengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// registryClient accesses a single repository using the OCI distribution API.
+// Bearer tokens are requested from the registry's token service when the registry asks for them.
+type registryClient struct {
+	client     *http.Client
+	baseURL    string
+	repository string
+	auth       httpclient.AuthConfig
+	token      string
+}
+
+func newRegistryClient(config *Config) (*registryClient, error) {
+	client, err := httpclient.New(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := config.registryURL()
+	if err != nil {
+		return nil, err
+	}
+	return &registryClient{
+		client:     client,
+		baseURL:    baseURL,
+		repository: config.Repository,
+		auth:       config.Auth,
+	}, nil
+}
+
+// resolveDigest finds the digest of the manifest the tag points to without downloading the manifest.
+// An empty digest is returned when the registry doesn't report it.
+func (c *registryClient) resolveDigest(ctx context.Context, tag string) (string, error) {
+	res, err := c.do(ctx, http.MethodHead, c.manifestPath(tag), manifestAcceptHeader())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status %s for manifest %s", res.Status, tag)
+	}
+	return res.Header.Get("Docker-Content-Digest"), nil
+}
+
+// manifest downloads the manifest for the given tag or digest.
+// The digest is calculated from the manifest contents.
+func (c *registryClient) manifest(ctx context.Context, reference string) (m manifest, digest string, err error) {
+	res, err := c.do(ctx, http.MethodGet, c.manifestPath(reference), manifestAcceptHeader())
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected HTTP status %s for manifest %s", res.Status, reference)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxManifestSize+1))
+	if err != nil {
+		return
+	}
+	if len(body) > maxManifestSize {
+		err = fmt.Errorf("manifest %s is too large", reference)
+		return
+	}
+	if err = json.Unmarshal(body, &m); err != nil {
+		return
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = strings.TrimSpace(strings.Split(res.Header.Get("Content-Type"), ";")[0])
+	}
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		err = fmt.Errorf("manifest %s is an index, which is not supported", reference)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	digest = "sha256:" + hex.EncodeToString(sum[:])
+	return
+}
+
+// blob opens a blob for reading. The returned reader verifies the contents against the digest.
+func (c *registryClient) blob(ctx context.Context, digest string) (*digestReader, error) {
+	verifier, err := newDigestVerifier(digest)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", c.repository, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s for blob %s", res.Status, digest)
+	}
+	return &digestReader{body: res.Body, digest: digest, hash: verifier}, nil
+}
+
+func (c *registryClient) manifestPath(reference string) string {
+	return fmt.Sprintf("/v2/%s/manifests/%s", c.repository, reference)
+}
+
+func (c *registryClient) do(
+	ctx context.Context,
+	method string,
+	path string,
+	header http.Header,
+) (*http.Response, error) {
+	res, err := c.doOnce(ctx, method, path, header)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// Authenticate according to the challenge, and try again
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, fmt.Errorf("registry authentication failed")
+	}
+	if c.token, err = c.fetchToken(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("failed to fetch registry token: %w", err)
+	}
+	return c.doOnce(ctx, method, path, header)
+}
+
+func (c *registryClient) doOnce(
+	ctx context.Context,
+	method string,
+	path string,
+	header http.Header,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	} else {
+		c.auth.Apply(req)
+	}
+	return c.client.Do(req)
+}
+
+// fetchToken requests a bearer token from the token service specified in the challenge.
+func (c *registryClient) fetchToken(ctx context.Context, challenge string) (string, error) {
+	params := map[string]string{}
+	for _, match := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("no realm in challenge %s", challenge)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", c.repository)
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.auth.Username != "" || c.auth.Password != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status %s from token service", res.Status)
+	}
+
+	var tokenRes struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return "", err
+	}
+	if tokenRes.Token != "" {
+		return tokenRes.Token, nil
+	}
+	if tokenRes.AccessToken != "" {
+		return tokenRes.AccessToken, nil
+	}
+	return "", fmt.Errorf("no token in token service response")
+}
+
+func manifestAcceptHeader() http.Header {
+	return http.Header{
+		"Accept": []string{
+			mediaTypeOCIManifest,
+			mediaTypeDockerManifest,
+			mediaTypeOCIIndex,
+			mediaTypeDockerManifestList,
+		},
+	}
+}
+
+func newDigestVerifier(digest string) (hash.Hash, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("unsupported digest %s", digest)
+	}
+	return sha256.New(), nil
+}
+
+type digestReader struct {
+	body   io.ReadCloser
+	digest string
+	hash   hash.Hash
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	return n, err
+}
+
+// Verify reads the rest of the blob, and checks that the contents match the digest.
+func (r *digestReader) Verify() error {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(r.hash.Sum(nil)); actual != r.digest {
+		return fmt.Errorf("blob digest mismatch: expected %s, got %s", r.digest, actual)
+	}
+	return nil
+}
+
+func (r *digestReader) Close() error {
+	return r.body.Close()
+}
@@ -3,20 +3,20 @@ package s3
 import (
 	"fmt"
 	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-type Config struct {
+type ClientConfig struct {
 	Endpoint        string `yaml:"endpoint"`
 	AccessKeyId     string `yaml:"accessKeyId"`
 	SecretAccessKey string `yaml:"secretAccessKey"`
 	SessionToken    string `yaml:"sessionToken"`
-	BucketName      string `yaml:"bucketName"`
-	BucketPrefix    string `yaml:"bucketPrefix"`
-	Directory       string `yaml:"directory"`
 	DisableTLS      bool   `yaml:"disableTls,omitempty"`
 }
 
-func (c *Config) Validate() error {
+func (c *ClientConfig) Validate() error {
 	if c.Endpoint == "" {
 		return fmt.Errorf("no S3 endpoint specified")
 	}
@@ -26,6 +26,27 @@ func (c *Config) Validate() error {
 	if c.SecretAccessKey == "" {
 		return fmt.Errorf("no S3 secret access key specified")
 	}
+	return nil
+}
+
+func (c *ClientConfig) NewClient() (*minio.Client, error) {
+	return minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKeyId, c.SecretAccessKey, c.SessionToken),
+		Secure: !c.DisableTLS,
+	})
+}
+
+type Config struct {
+	ClientConfig `yaml:",inline"`
+	BucketName   string `yaml:"bucketName"`
+	BucketPrefix string `yaml:"bucketPrefix"`
+	Directory    string `yaml:"directory"`
+}
+
+func (c *Config) Validate() error {
+	if err := c.ClientConfig.Validate(); err != nil {
+		return err
+	}
 	if c.BucketName == "" {
 		return fmt.Errorf("no S3 bucket name specified")
 	}
@@ -35,6 +56,26 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// ObjectConfig points to a single S3 object instead of a set of objects.
+type ObjectConfig struct {
+	ClientConfig `yaml:",inline"`
+	BucketName   string `yaml:"bucketName"`
+	ObjectKey    string `yaml:"objectKey"`
+}
+
+func (c *ObjectConfig) Validate() error {
+	if err := c.ClientConfig.Validate(); err != nil {
+		return err
+	}
+	if c.BucketName == "" {
+		return fmt.Errorf("no S3 bucket name specified")
+	}
+	if c.ObjectKey == "" {
+		return fmt.Errorf("no S3 object key specified")
+	}
+	return nil
+}
+
 func (c *Config) sanitizeBucketPrefix() {
 	c.BucketPrefix = sanitizeBucketPrefix(c.BucketPrefix)
 }
@@ -2,44 +2,36 @@ package s3
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"path/filepath"
-	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
 	"gitlab.com/lepovirta/konvahti/internal/file"
 	"gitlab.com/lepovirta/konvahti/internal/stat"
 )
 
-const (
-	latestLinkName = "latest"
-)
-
 type S3Source struct {
 	fs              billy.Filesystem
 	config          Config
 	minioClient     *minio.Client
 	lastChanges     stat.Stat
+	changes         changeset.ChangeSet
 	latestDirectory string
 }
 
 func (s *S3Source) Setup(fs billy.Filesystem, config Config) (err error) {
 	config.sanitizeBucketPrefix()
-	s.minioClient, err = minio.New(config.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.AccessKeyId, config.SecretAccessKey, config.SessionToken),
-		Secure: !config.DisableTLS,
-	})
+	s.minioClient, err = config.NewClient()
 	if err != nil {
 		return
 	}
 	s.fs = fs
 	s.config = config
 	s.lastChanges = nil
-	s.latestDirectory = fs.Join(config.Directory, latestLinkName)
+	s.changes = nil
+	s.latestDirectory = fs.Join(config.Directory, file.LatestLinkName)
 	return nil
 }
 
@@ -57,8 +49,9 @@ func (s *S3Source) Refresh(ctx context.Context) ([]string, error) {
 	}
 
 	updated, existing := s.lastChanges.Updated(files)
+	removed := s.lastChanges.Removed(files)
 
-	nextDirectoryName := timestampString()
+	nextDirectoryName := file.SnapshotName()
 	nextDirectory := s.fs.Join(s.config.Directory, nextDirectoryName)
 
 	if err := file.SwapDirectory(
@@ -70,13 +63,25 @@ func (s *S3Source) Refresh(ctx context.Context) ([]string, error) {
 		return nil, err
 	}
 
-	s.lastChanges = files
-
-	updatedFiles := make([]string, 0, len(updated))
+	changes := make(changeset.ChangeSet, 0, len(updated)+len(removed))
 	for _, objectKey := range updated {
-		updatedFiles = append(updatedFiles, s.objectKeyToFilename(objectKey))
+		kind := changeset.Modified
+		if _, ok := s.lastChanges[objectKey]; !ok {
+			kind = changeset.Added
+		}
+		changes = append(changes, changeset.Change{Kind: kind, Path: s.objectKeyToFilename(objectKey)})
+	}
+	for _, objectKey := range removed {
+		changes = append(changes, changeset.Change{Kind: changeset.Deleted, Path: s.objectKeyToFilename(objectKey)})
 	}
-	return updatedFiles, nil
+	s.lastChanges = files
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *S3Source) Changes() changeset.ChangeSet {
+	return s.changes
 }
 
 func (s *S3Source) objectKeyToFilename(objectKey string) string {
@@ -103,10 +108,6 @@ func (s *S3Source) createDirectoryPopulator(
 	}
 }
 
-func timestampString() string {
-	return fmt.Sprintf("%d", time.Now().Unix())
-}
-
 func (s *S3Source) pullObject(
 	ctx context.Context,
 	fs billy.Filesystem,
@@ -119,11 +120,11 @@ func (s *S3Source) pullObject(
 	}
 
 	logger.Debug().Str("objectkey", objectKey).Str("filename", filename).Msg("preparing file before download")
-	file, err := s.prepareTargetFile(fs, filename)
+	targetFile, err := file.CreateFile(fs, filename)
 	if err != nil {
 		return err
 	}
-	defer loggedFileClose(file, logger)
+	defer loggedFileClose(targetFile, logger)
 
 	object, err := s.minioClient.GetObject(ctx, s.config.BucketName, objectKey, minio.GetObjectOptions{})
 	if err != nil {
@@ -136,7 +137,7 @@ func (s *S3Source) pullObject(
 	}()
 
 	logger.Debug().Str("objectKey", objectKey).Str("filename", filename).Msg("downloading file")
-	_, err = io.Copy(file, object)
+	_, err = io.Copy(targetFile, object)
 	return err
 }
 
@@ -151,11 +152,11 @@ func (s *S3Source) copyLocalFile(
 	}
 
 	logger.Debug().Str("objectkey", objectKey).Str("filename", filename).Msg("preparing file before copy")
-	file, err := s.prepareTargetFile(fs, filename)
+	targetFile, err := file.CreateFile(fs, filename)
 	if err != nil {
 		return err
 	}
-	defer loggedFileClose(file, logger)
+	defer loggedFileClose(targetFile, logger)
 
 	sourceFile, err := s.fs.Open(s.fs.Join(s.latestDirectory, filename))
 	if err != nil {
@@ -164,7 +165,7 @@ func (s *S3Source) copyLocalFile(
 	defer loggedFileClose(sourceFile, logger)
 
 	logger.Debug().Str("objectKey", objectKey).Str("filename", filename).Msg("copying file")
-	_, err = io.Copy(file, sourceFile)
+	_, err = io.Copy(targetFile, sourceFile)
 	return err
 }
 
@@ -174,15 +175,6 @@ func loggedFileClose(file billy.File, logger zerolog.Logger) {
 	}
 }
 
-func (s *S3Source) prepareTargetFile(fs billy.Filesystem, filename string) (billy.File, error) {
-	dirname := filepath.Dir(filename)
-
-	if err := fs.MkdirAll(dirname, 0750); err != nil {
-		return nil, err
-	}
-	return fs.Create(filename)
-}
-
 func (s *S3Source) listFiles(ctx context.Context) (files stat.Stat, err error) {
 	files = make(stat.Stat, 100)
 
@@ -0,0 +1,50 @@
+package sftp
+
+import (
+	"fmt"
+	"net"
+	"path"
+
+	"gitlab.com/lepovirta/konvahti/internal/sshauth"
+)
+
+const (
+	defaultPort = "22"
+)
+
+type Config struct {
+	Address   string         `yaml:"address"`
+	Path      string         `yaml:"path"`
+	Directory string         `yaml:"directory"`
+	SSHAuth   sshauth.Config `yaml:"sshAuth"`
+}
+
+func (c *Config) Validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("no SFTP address specified")
+	}
+	if c.Path == "" {
+		return fmt.Errorf("no SFTP path specified")
+	}
+	if c.Directory == "" {
+		return fmt.Errorf("no local directory specified")
+	}
+	if err := c.SSHAuth.Validate(); err != nil {
+		return fmt.Errorf("invalid SSH auth: %w", err)
+	}
+	if !c.SSHAuth.HasKnownHosts() {
+		return fmt.Errorf("no SSH known hosts specified")
+	}
+	return nil
+}
+
+func (c *Config) address() string {
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return net.JoinHostPort(c.Address, defaultPort)
+	}
+	return c.Address
+}
+
+func (c *Config) remotePath() string {
+	return path.Clean(c.Path)
+}
@@ -0,0 +1,211 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/stat"
+	"golang.org/x/crypto/ssh"
+)
+
+type SFTPSource struct {
+	fs              billy.Filesystem
+	config          Config
+	sshConfig       *ssh.ClientConfig
+	lastVersions    stat.Versions
+	latestDirectory string
+	changes         changeset.ChangeSet
+}
+
+func (s *SFTPSource) Setup(fs billy.Filesystem, config Config) (err error) {
+	s.sshConfig, err = config.SSHAuth.ClientConfig()
+	if err != nil {
+		return
+	}
+	s.fs = fs
+	s.config = config
+	s.lastVersions = nil
+	s.latestDirectory = fs.Join(config.Directory, file.LatestLinkName)
+	s.changes = nil
+	return nil
+}
+
+func (s *SFTPSource) GetDirectory() string {
+	return s.latestDirectory
+}
+
+func (s *SFTPSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := s.getLogCtx(zerolog.Ctx(ctx))
+	logger.Info().Msg("refreshing files from SFTP")
+
+	client, closeClient, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient(logger)
+
+	versions, remoteDir, err := s.listFiles(client)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := s.lastVersions.Changes(versions)
+	if len(changes) == 0 {
+		logger.Debug().Msg("no changes found")
+		return nil, nil
+	}
+
+	updated, existing := s.lastVersions.Updated(versions)
+	nextDirectory := s.fs.Join(s.config.Directory, file.SnapshotName())
+	if err := file.SwapDirectory(
+		s.fs,
+		s.latestDirectory,
+		nextDirectory,
+		func(fs billy.Filesystem) error {
+			for _, filename := range updated {
+				if err := s.pullFile(client, fs, remoteDir, filename, logger); err != nil {
+					return err
+				}
+			}
+			for _, filename := range existing {
+				logger.Debug().Str("filename", filename).Msg("copying file")
+				if err := file.CopyFile(s.fs, s.fs.Join(s.latestDirectory, filename), fs, filename); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	s.lastVersions = versions
+	s.changes = changes
+	return changes.Paths(), nil
+}
+
+// Changes tells which of the files were added, modified, or deleted on the latest refresh.
+func (s *SFTPSource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+func (s *SFTPSource) connect(ctx context.Context) (*sftp.Client, func(zerolog.Logger), error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.address())
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.config.address(), s.sshConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, nil, err
+	}
+
+	return client, func(logger zerolog.Logger) {
+		if err := client.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close SFTP client")
+		}
+		if err := sshClient.Close(); err != nil {
+			logger.Debug().Err(err).Msg("failed to close SSH client")
+		}
+	}, nil
+}
+
+// listFiles lists the remote files recursively, and returns the remote directory that the file names are relative to.
+// When the remote path is a single file, the file is listed using its name.
+// The size and the modification time of each file is used as its version.
+func (s *SFTPSource) listFiles(client *sftp.Client) (stat.Versions, string, error) {
+	root := s.config.remotePath()
+	rootInfo, err := client.Stat(root)
+	if err != nil {
+		return nil, "", err
+	}
+	remoteDir := root
+	if !rootInfo.IsDir() {
+		remoteDir = path.Dir(root)
+	}
+
+	versions := make(stat.Versions, 100)
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, "", err
+		}
+		info := walker.Stat()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		filename, err := relativePath(remoteDir, walker.Path())
+		if err != nil {
+			return nil, "", err
+		}
+		versions[filename] = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+	}
+	return versions, remoteDir, nil
+}
+
+// relativePath returns the remote path relative to the remote directory.
+// The remote paths always use slashes as separators.
+func relativePath(remoteDir string, remotePath string) (string, error) {
+	rel, err := filepath.Rel(filepath.FromSlash(remoteDir), filepath.FromSlash(remotePath))
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("remote file %s is not under %s", remotePath, remoteDir)
+	}
+	return rel, nil
+}
+
+func (s *SFTPSource) pullFile(
+	client *sftp.Client,
+	fs billy.Filesystem,
+	remoteDir string,
+	filename string,
+	logger zerolog.Logger,
+) error {
+	remoteFile, err := client.Open(path.Join(remoteDir, filename))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := remoteFile.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close remote file")
+		}
+	}()
+
+	logger.Debug().Str("filename", filename).Msg("downloading file")
+	return file.WriteFile(fs, filename, remoteFile)
+}
+
+func (s *SFTPSource) getLogCtx(logger *zerolog.Logger) zerolog.Logger {
+	return logger.With().
+		Str("stage", "refresh").
+		Str("sftpAddress", s.config.Address).
+		Str("sftpPath", s.config.Path).
+		Logger()
+}
@@ -0,0 +1,297 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/sshauth"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testDataDir  = "_testdata"
+	testUsername = "konvahti"
+	testPassword = "supersecret"
+)
+
+// startTestServer starts an in-process SSH server that serves SFTP requests,
+// and writes its host key to the given known hosts file.
+func startTestServer(t *testing.T, knownHostsPath string) (string, func()) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUsername && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(knownHostsLine+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, serverConfig)
+		}
+	}()
+	return address, func() {
+		_ = listener.Close()
+	}
+}
+
+func serveTestConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				_ = req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		_ = server.Serve()
+		_ = server.Close()
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New("")
+	defer func() {
+		if err := util.RemoveAll(fs, testDataDir); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	remoteDir, err := filepath.Abs(filepath.Join(testDataDir, "remote"))
+	if !a.NoError(err) {
+		return
+	}
+	if err := fs.MkdirAll(filepath.Join(remoteDir, "configs"), 0750); !a.NoError(err) {
+		return
+	}
+	for filename, content := range map[string]string{
+		"app.yaml":         "version: 1",
+		"configs/db.yaml":  "host: localhost",
+		"configs/old.yaml": "deprecated: true",
+	} {
+		if err := util.WriteFile(fs, filepath.Join(remoteDir, filename), []byte(content), 0640); !a.NoError(err) {
+			return
+		}
+	}
+
+	knownHostsPath := filepath.Join(testDataDir, "known_hosts")
+	address, stopServer := startTestServer(t, knownHostsPath)
+	defer stopServer()
+
+	var source SFTPSource
+	if err := source.Setup(fs, Config{
+		Address:   address,
+		Path:      remoteDir,
+		Directory: filepath.Join(testDataDir, "local"),
+		SSHAuth: sshauth.Config{
+			Username:       testUsername,
+			Password:       testPassword,
+			KnownHostsPath: knownHostsPath,
+		},
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/db.yaml", "configs/old.yaml"}, changed)
+
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Empty(changed)
+
+	// Change one file and remove another
+	if err := util.WriteFile(fs, filepath.Join(remoteDir, "app.yaml"), []byte("version: 22"), 0640); !a.NoError(err) {
+		return
+	}
+	if err := os.Chtimes(filepath.Join(remoteDir, "app.yaml"), time.Now(), time.Now().Add(time.Hour)); !a.NoError(err) {
+		return
+	}
+	if err := fs.Remove(filepath.Join(remoteDir, "configs/old.yaml")); !a.NoError(err) {
+		return
+	}
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"app.yaml", "configs/old.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "app.yaml"},
+		{Kind: changeset.Deleted, Path: "configs/old.yaml"},
+	}, source.Changes())
+
+	for filename, content := range map[string]string{
+		"app.yaml":        "version: 22",
+		"configs/db.yaml": "host: localhost",
+	} {
+		data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), filename))
+		if a.NoError(err) {
+			a.Equal(content, string(data))
+		}
+	}
+}
+
+func TestRefreshUnknownHost(t *testing.T) {
+	a := assert.New(t)
+	fs := osfs.New("")
+	defer func() {
+		if err := util.RemoveAll(fs, testDataDir); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+	if err := fs.MkdirAll(testDataDir, 0750); !a.NoError(err) {
+		return
+	}
+
+	address, stopServer := startTestServer(t, filepath.Join(testDataDir, "known_hosts"))
+	defer stopServer()
+
+	otherKnownHostsPath := filepath.Join(testDataDir, "other_known_hosts")
+	_, stopOtherServer := startTestServer(t, otherKnownHostsPath)
+	defer stopOtherServer()
+
+	var source SFTPSource
+	if err := source.Setup(fs, Config{
+		Address:   address,
+		Path:      "/",
+		Directory: filepath.Join(testDataDir, "local"),
+		SSHAuth: sshauth.Config{
+			Username:       testUsername,
+			Password:       testPassword,
+			KnownHostsPath: otherKnownHostsPath,
+		},
+	}); !a.NoError(err) {
+		return
+	}
+
+	_, err := source.Refresh(context.Background())
+	a.Error(err)
+}
+
+func TestRefreshSingleFile(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New("")
+	defer func() {
+		if err := util.RemoveAll(fs, testDataDir); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	remoteDir, err := filepath.Abs(filepath.Join(testDataDir, "remote"))
+	if !a.NoError(err) {
+		return
+	}
+	for filename, content := range map[string]string{
+		"app.yaml": "version: 1",
+		"db.yaml":  "host: localhost",
+	} {
+		if err := util.WriteFile(fs, filepath.Join(remoteDir, filename), []byte(content), 0640); !a.NoError(err) {
+			return
+		}
+	}
+
+	knownHostsPath := filepath.Join(testDataDir, "known_hosts")
+	address, stopServer := startTestServer(t, knownHostsPath)
+	defer stopServer()
+
+	var source SFTPSource
+	if err := source.Setup(fs, Config{
+		Address:   address,
+		Path:      filepath.Join(remoteDir, "app.yaml"),
+		Directory: filepath.Join(testDataDir, "local"),
+		SSHAuth: sshauth.Config{
+			Username:       testUsername,
+			Password:       testPassword,
+			KnownHostsPath: knownHostsPath,
+		},
+	}); !a.NoError(err) {
+		return
+	}
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal([]string{"app.yaml"}, changed)
+	data, err := util.ReadFile(fs, fs.Join(source.GetDirectory(), "app.yaml"))
+	if a.NoError(err) {
+		a.Equal("version: 1", string(data))
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	a := assert.New(t)
+	for _, tc := range []struct {
+		remoteDir  string
+		remotePath string
+		expected   string
+	}{
+		{"/", "/etc/app.yaml", "etc/app.yaml"},
+		{"/srv/configs", "/srv/configs/app.yaml", "app.yaml"},
+		{"/srv/configs", "/srv/configs/sub/db.yaml", "sub/db.yaml"},
+		{"configs", "configs/app.yaml", "app.yaml"},
+		{".", "app.yaml", "app.yaml"},
+	} {
+		rel, err := relativePath(tc.remoteDir, tc.remotePath)
+		if a.NoError(err) {
+			a.Equal(tc.expected, rel)
+		}
+	}
+	_, err := relativePath("/srv/configs", "/srv/other.yaml")
+	a.Error(err)
+}
@@ -0,0 +1,213 @@
+package sshauth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type Config struct {
+	Username       string `yaml:"username"`
+	KeyPath        string `yaml:"keyPath"`
+	Key            string `yaml:"key,omitempty"`
+	KeyPassword    string `yaml:"keyPassword"`
+	UseAgent       bool   `yaml:"useAgent,omitempty"`
+	Password       string `yaml:"password,omitempty"`
+	KnownHostsPath string `yaml:"knownHostsPath,omitempty"`
+	KnownHosts     string `yaml:"knownHosts,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Username == "" {
+		return fmt.Errorf("no SSH username specified")
+	}
+	if c.KeyPath != "" && c.Key != "" {
+		return fmt.Errorf("only one of SSH key path or key can be specified")
+	}
+	if !c.HasCredentials() {
+		return fmt.Errorf("no SSH key, agent, or password specified")
+	}
+	return nil
+}
+
+// HasCredentials returns true when any of the authentication methods is configured.
+func (c *Config) HasCredentials() bool {
+	return c.hasKey() || c.UseAgent || c.Password != ""
+}
+
+// HasKnownHosts returns true when the host keys are verified using the configured known hosts.
+func (c *Config) HasKnownHosts() bool {
+	return c.KnownHostsPath != "" || c.KnownHosts != ""
+}
+
+func (c *Config) hasKey() bool {
+	return c.KeyPath != "" || c.Key != ""
+}
+
+// Signer parses the private key from the key file or from the inline key.
+func (c *Config) Signer() (ssh.Signer, error) {
+	keyPEM := []byte(c.Key)
+	if c.KeyPath != "" {
+		var err error
+		if keyPEM, err = os.ReadFile(filepath.Clean(c.KeyPath)); err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+	}
+	if c.KeyPassword != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(keyPEM, []byte(c.KeyPassword))
+	}
+	return ssh.ParsePrivateKey(keyPEM)
+}
+
+// Agent lists the keys from the SSH agent found using the SSH_AUTH_SOCK environment variable.
+// The agent connection is opened on the first use, and it's kept open, because the agent is used
+// for signing with the keys. A new connection is opened when the previous one fails.
+type Agent struct {
+	mutex  sync.Mutex
+	conn   net.Conn
+	client agent.ExtendedAgent
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.client == nil {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("no SSH agent found: SSH_AUTH_SOCK not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		}
+		a.conn = conn
+		a.client = agent.NewClient(conn)
+	}
+	signers, err := a.client.Signers()
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("failed to list keys from SSH agent: %w", err)
+	}
+	return signers, nil
+}
+
+// Close closes the agent connection.
+func (a *Agent) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.close()
+}
+
+func (a *Agent) close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	a.client = nil
+	return err
+}
+
+func (c *Config) AuthMethods() ([]ssh.AuthMethod, error) {
+	methods := make([]ssh.AuthMethod, 0, 3)
+	if c.hasKey() {
+		signer, err := c.Signer()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if c.UseAgent {
+		methods = append(methods, ssh.PublicKeysCallback((&Agent{}).Signers))
+	}
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password))
+	}
+	return methods, nil
+}
+
+// HostKeyCallback verifies the host keys strictly using the known hosts file and the inline known hosts.
+// Nil is returned when no known hosts are configured.
+func (c *Config) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	if !c.HasKnownHosts() {
+		return nil, nil
+	}
+	var files []string
+	if c.KnownHostsPath != "" {
+		files = append(files, c.KnownHostsPath)
+	}
+	if c.KnownHosts != "" {
+		// The known hosts are read when the callback is created, so the file is not needed afterwards
+		file, err := os.CreateTemp("", "known_hosts")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(c.KnownHosts + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file.Name())
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH known hosts: %w", err)
+	}
+	return strictHostKeyCallback(callback), nil
+}
+
+// strictHostKeyCallback explains why the host key was rejected.
+func strictHostKeyCallback(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("SSH host key %s for %s not found from known hosts: %w", fingerprint, hostname, err)
+			}
+			return fmt.Errorf(
+				"SSH host key for %s has changed to %s, which doesn't match the known hosts: %w",
+				hostname, fingerprint, err,
+			)
+		}
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			return fmt.Errorf("SSH host key %s for %s has been revoked: %w", fingerprint, hostname, err)
+		}
+		return err
+	}
+}
+
+func (c *Config) ClientConfig() (*ssh.ClientConfig, error) {
+	authMethods, err := c.AuthMethods()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := c.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	if hostKeyCallback == nil {
+		return nil, fmt.Errorf("no SSH known hosts specified")
+	}
+	return &ssh.ClientConfig{
+		User:            c.Username,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
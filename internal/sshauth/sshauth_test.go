@@ -0,0 +1,130 @@
+package sshauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, signer
+}
+
+func TestInlineKeySigner(t *testing.T) {
+	a := assert.New(t)
+	privateKey, signer := newKey(t)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Username: "konvahti",
+		Key:      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+	a.NoError(config.Validate())
+
+	parsed, err := config.Signer()
+	if a.NoError(err) {
+		a.Equal(signer.PublicKey().Marshal(), parsed.PublicKey().Marshal())
+	}
+
+	config.KeyPath = "/etc/konvahti/id_ed25519"
+	a.Error(config.Validate())
+}
+
+func TestAgent(t *testing.T) {
+	a := assert.New(t)
+	privateKey, signer := newKey(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: privateKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	var sshAgent Agent
+	defer sshAgent.Close()
+	for i := 0; i < 3; i++ {
+		signers, err := sshAgent.Signers()
+		if a.NoError(err) && a.Len(signers, 1) {
+			a.Equal(signer.PublicKey().Marshal(), signers[0].PublicKey().Marshal())
+		}
+	}
+	// The same connection is used for all of the requests
+	a.Equal(int32(1), atomic.LoadInt32(&connections))
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	var missingAgent Agent
+	_, err = missingAgent.Signers()
+	a.Error(err)
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	a := assert.New(t)
+	_, hostSigner := newKey(t)
+	_, otherSigner := newKey(t)
+	address := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	hostname := "git.example.org:2222"
+
+	config := Config{
+		KnownHosts: knownhosts.Line([]string{knownhosts.Normalize(hostname)}, hostSigner.PublicKey()),
+	}
+	callback, err := config.HostKeyCallback()
+	if !a.NoError(err) {
+		return
+	}
+
+	a.NoError(callback(hostname, address, hostSigner.PublicKey()))
+
+	err = callback(hostname, address, otherSigner.PublicKey())
+	if a.Error(err) {
+		a.Contains(err.Error(), "has changed")
+	}
+
+	err = callback("unknown.example.org:22", address, hostSigner.PublicKey())
+	if a.Error(err) {
+		a.Contains(err.Error(), "not found from known hosts")
+	}
+
+	config = Config{}
+	callback, err = config.HostKeyCallback()
+	a.NoError(err)
+	a.Nil(callback)
+}
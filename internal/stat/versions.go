@@ -0,0 +1,48 @@
+package stat
+
+import "gitlab.com/lepovirta/konvahti/internal/changeset"
+
+// Versions maps file names to opaque version identifiers such as
+// content hashes, ETags, or object generations.
+type Versions map[string]string
+
+func (fv Versions) Updated(next Versions) (changed, existing []string) {
+	changed = make([]string, 0, len(next))
+	existing = make([]string, 0, len(next))
+	for k, v := range next {
+		if prevV, ok := fv[k]; ok && prevV == v {
+			existing = append(existing, k)
+		} else {
+			changed = append(changed, k)
+		}
+	}
+	return
+}
+
+func (fv Versions) Removed(next Versions) (removed []string) {
+	removed = make([]string, 0, len(fv))
+	for k := range fv {
+		if _, ok := next[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return
+}
+
+// Changes lists the files that were added, modified, or deleted in the next versions.
+func (fv Versions) Changes(next Versions) changeset.ChangeSet {
+	changes := make(changeset.ChangeSet, 0, len(next))
+	for k, v := range next {
+		if prevV, ok := fv[k]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Added, Path: k})
+		} else if prevV != v {
+			changes = append(changes, changeset.Change{Kind: changeset.Modified, Path: k})
+		}
+	}
+	for k := range fv {
+		if _, ok := next[k]; !ok {
+			changes = append(changes, changeset.Change{Kind: changeset.Deleted, Path: k})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,47 @@
+package stat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+)
+
+var (
+	v1 = Versions{
+		"1.txt": "a",
+		"2.txt": "b",
+		"3.txt": "c",
+	}
+	v2 = Versions{
+		"1.txt": "a",
+		"2.txt": "bb",
+		"4.txt": "d",
+	}
+)
+
+func TestVersionsUpdated(t *testing.T) {
+	u1, e1 := v1.Updated(v2)
+	u2, e2 := Versions(nil).Updated(v1)
+
+	assert.ElementsMatch(t, []string{"2.txt", "4.txt"}, u1)
+	assert.ElementsMatch(t, []string{"1.txt"}, e1)
+	assert.ElementsMatch(t, []string{"1.txt", "2.txt", "3.txt"}, u2)
+	assert.Empty(t, e2)
+}
+
+func TestVersionsRemoved(t *testing.T) {
+	assert.ElementsMatch(t, []string{"3.txt"}, v1.Removed(v2))
+	assert.ElementsMatch(t, []string{"4.txt"}, v2.Removed(v1))
+	assert.Empty(t, Versions(nil).Removed(v1))
+}
+
+func TestVersionsChanges(t *testing.T) {
+	assert.ElementsMatch(t, changeset.ChangeSet{
+		{Kind: changeset.Modified, Path: "2.txt"},
+		{Kind: changeset.Added, Path: "4.txt"},
+		{Kind: changeset.Deleted, Path: "3.txt"},
+	}, v1.Changes(v2))
+	assert.Empty(t, v1.Changes(v1))
+	assert.Equal(t, changeset.FromPaths(changeset.Added, []string{"1.txt"}), Versions(nil).Changes(Versions{"1.txt": "a"}))
+}
@@ -0,0 +1,249 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rs/zerolog"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/env"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+)
+
+// The environment variables from the sources start with this namespace
+const envVarNamespace = "KONVAHTI_"
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// compositeSource combines multiple file sources into a single directory tree.
+// Each source is mounted to its own sub-directory using a symlink to the source's directory,
+// so that each of the sources keeps updating its own files atomically.
+type compositeSource struct {
+	directory      string
+	mounts         []mountedSource
+	changes        changeset.ChangeSet
+	pendingFiles   []string
+	pendingChanges changeset.ChangeSet
+}
+
+type mountedSource struct {
+	path   string
+	source FileSource
+}
+
+func (s *compositeSource) Setup(env *env.Env, directory string, configs []MountConfig) error {
+	s.directory = directory
+	s.mounts = make([]mountedSource, 0, len(configs))
+	for i := range configs {
+		config := &configs[i]
+		source, err := singleFileSourceFromConfig(env, &config.SourceConfig, config.Path)
+		if err != nil {
+			return fmt.Errorf("failed to set up source %s: %w", config.Path, err)
+		}
+		if err := mountDirectory(env.Fs, source.GetDirectory(), env.Fs.Join(directory, config.Path)); err != nil {
+			return fmt.Errorf("failed to mount source %s: %w", config.Path, err)
+		}
+		s.mounts = append(s.mounts, mountedSource{path: config.Path, source: source})
+	}
+	return nil
+}
+
+func (s *compositeSource) GetDirectory() string {
+	return s.directory
+}
+
+// Refresh refreshes all of the sources, and prefixes the changed files with the source paths.
+// An error is returned when any of the sources fails. The changes from the other sources
+// are kept until the next successful refresh, so that the actions are run for them once
+// all of the sources are up to date.
+func (s *compositeSource) Refresh(ctx context.Context) ([]string, error) {
+	logger := zerolog.Ctx(ctx)
+	var errs []string
+	for _, mount := range s.mounts {
+		sourceLogger := logger.With().Str("sourcePath", mount.path).Logger()
+		files, err := mount.source.Refresh(sourceLogger.WithContext(ctx))
+		if err != nil {
+			sourceLogger.Error().Err(err).Msg("refreshing source failed")
+			errs = append(errs, fmt.Sprintf("refreshing source %s failed: %s", mount.path, err))
+			continue
+		}
+		for _, filename := range files {
+			s.pendingFiles = appendUniqueFile(s.pendingFiles, path.Join(mount.path, filename))
+		}
+		for _, change := range sourceChanges(mount.source, files).WithPrefix(mount.path) {
+			s.pendingChanges = appendUniqueChange(s.pendingChanges, change)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	changedFiles := s.pendingFiles
+	s.changes = s.pendingChanges
+	s.pendingFiles = nil
+	s.pendingChanges = nil
+	return changedFiles, nil
+}
+
+func appendUniqueFile(files []string, filename string) []string {
+	for _, f := range files {
+		if f == filename {
+			return files
+		}
+	}
+	return append(files, filename)
+}
+
+func appendUniqueChange(changes changeset.ChangeSet, change changeset.Change) changeset.ChangeSet {
+	for _, c := range changes {
+		if c == change {
+			return changes
+		}
+	}
+	return append(changes, change)
+}
+
+// Changes lists the changes from all of the sources prefixed with the source paths.
+func (s *compositeSource) Changes() changeset.ChangeSet {
+	return s.changes
+}
+
+// Notifications merges the notifications from all of the sources that support them.
+func (s *compositeSource) Notifications(ctx context.Context) <-chan struct{} {
+	var channels []<-chan struct{}
+	for _, mount := range s.mounts {
+		if notifier, ok := mount.source.(Notifier); ok {
+			if ch := notifier.Notifications(ctx); ch != nil {
+				channels = append(channels, ch)
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	notifyCh := make(chan struct{}, 1)
+	for _, ch := range channels {
+		go func(ch <-chan struct{}) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case _, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case notifyCh <- struct{}{}:
+					default:
+						// Notification already pending
+					}
+				}
+			}
+		}(ch)
+	}
+	return notifyCh
+}
+
+// Acknowledge acknowledges the steps of all of the sources that deliver the changes in steps.
+func (s *compositeSource) Acknowledge() error {
+	for _, mount := range s.mounts {
+		if stepSource, ok := mount.source.(StepSource); ok {
+			if err := stepSource.Acknowledge(); err != nil {
+				return fmt.Errorf("acknowledging source %s failed: %w", mount.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HasMoreSteps tells whether any of the sources has steps left.
+func (s *compositeSource) HasMoreSteps() bool {
+	for _, mount := range s.mounts {
+		if stepSource, ok := mount.source.(StepSource); ok && stepSource.HasMoreSteps() {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvVars lists the environment variables from all of the sources. The source paths are added
+// to the variable names, so that the variables from similar sources don't overlap
+// (e.g. KONVAHTI_TEMPLATES_GIT_COMMIT for the path "templates").
+func (s *compositeSource) EnvVars() (result envvars.EnvVars) {
+	for _, mount := range s.mounts {
+		if envVarSource, ok := mount.source.(EnvVarSource); ok {
+			result = result.Join(mountEnvVars(mount.path, envVarSource.EnvVars()))
+		}
+	}
+	return
+}
+
+// Close closes all of the sources that hold resources.
+func (s *compositeSource) Close() error {
+	var errs []string
+	for _, mount := range s.mounts {
+		if closer, ok := mount.source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Sprintf("closing source %s failed: %s", mount.path, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func mountEnvVars(mountPath string, envVars envvars.EnvVars) envvars.EnvVars {
+	prefix := strings.ToUpper(nonAlphanumeric.ReplaceAllString(mountPath, "_")) + "_"
+	result := make(envvars.EnvVars, 0, len(envVars))
+	for _, envVar := range envVars {
+		if strings.HasPrefix(envVar, envVarNamespace) {
+			result = append(result, envVarNamespace+prefix+envVar[len(envVarNamespace):])
+		} else {
+			result = append(result, prefix+envVar)
+		}
+	}
+	return result
+}
+
+// mountDirectory creates a symlink to the target directory. An existing symlink is replaced.
+func mountDirectory(fs billy.Filesystem, target, link string) error {
+	if info, err := fs.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s already exists and is not a symlink", link)
+		}
+		if err := fs.Remove(link); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := fs.MkdirAll(filepath.Dir(link), 0750); err != nil {
+		return err
+	}
+	return fs.Symlink(symlinkTarget(target, link), link)
+}
+
+// symlinkTarget resolves relative targets relative to the link location,
+// so that the links work regardless of the working directory.
+func symlinkTarget(target, link string) string {
+	if filepath.IsAbs(target) {
+		return target
+	}
+	if relTarget, err := filepath.Rel(filepath.Dir(link), target); err == nil {
+		return relTarget
+	}
+	if absTarget, err := filepath.Abs(target); err == nil {
+		return absTarget
+	}
+	return target
+}
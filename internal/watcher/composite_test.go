@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/env"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+	"gitlab.com/lepovirta/konvahti/internal/local"
+)
+
+const (
+	testDataDir = "_testdata"
+)
+
+const testCompositeConfig = `
+name: combined
+directory: combined
+sources:
+- path: templates
+  local:
+    directory: sources/templates
+    disableNotifications: true
+- path: values/secrets
+  local:
+    directory: sources/secrets
+    disableNotifications: true
+actions:
+- command: ["true"]
+`
+
+func TestCompositeRefresh(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	for filename, content := range map[string]string{
+		"sources/templates/app.tmpl": "{{ .version }}",
+		"sources/secrets/app.yaml":   "version: 1",
+	} {
+		if err := util.WriteFile(fs, filename, []byte(content), 0660); !a.NoError(err) {
+			return
+		}
+	}
+
+	var config Config
+	if err := config.FromYAML(strings.NewReader(testCompositeConfig)); !a.NoError(err) {
+		return
+	}
+	if err := config.Validate(); !a.NoError(err) {
+		return
+	}
+	source, err := fileSourceFromConfig(&env.Env{Fs: fs}, &config)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal("combined", source.GetDirectory())
+
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"templates/app.tmpl", "values/secrets/app.yaml"}, changed)
+
+	if err := util.WriteFile(fs, "sources/secrets/app.yaml", []byte("version: 2"), 0660); !a.NoError(err) {
+		return
+	}
+	changed, err = source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal([]string{"values/secrets/app.yaml"}, changed)
+	a.Equal(
+		changeset.ChangeSet{{Kind: changeset.Modified, Path: "values/secrets/app.yaml"}},
+		source.(ChangeSetSource).Changes(),
+	)
+
+	data, err := util.ReadFile(fs, "combined/values/secrets/app.yaml")
+	if a.NoError(err) {
+		a.Equal("version: 2", string(data))
+	}
+
+	// Setting up the same mounts again replaces the existing links
+	_, err = fileSourceFromConfig(&env.Env{Fs: fs}, &config)
+	a.NoError(err)
+}
+
+func TestCompositeRefreshFailure(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	fs := osfs.New(testDataDir)
+	defer func() {
+		if err := util.RemoveAll(fs, "."); err != nil {
+			log.Error().Err(err).Msg("failed to erase test files")
+		}
+	}()
+
+	if err := util.WriteFile(fs, "sources/templates/app.tmpl", []byte("{{ .version }}"), 0660); !a.NoError(err) {
+		return
+	}
+	var config Config
+	if err := config.FromYAML(strings.NewReader(testCompositeConfig)); !a.NoError(err) {
+		return
+	}
+	source, err := fileSourceFromConfig(&env.Env{Fs: fs}, &config)
+	if !a.NoError(err) {
+		return
+	}
+
+	// The secrets directory is missing
+	_, err = source.Refresh(ctx)
+	a.Error(err)
+
+	// The changes from the working source are reported once all of the sources succeed
+	if err := util.WriteFile(fs, "sources/secrets/app.yaml", []byte("version: 1"), 0660); !a.NoError(err) {
+		return
+	}
+	changed, err := source.Refresh(ctx)
+	if !a.NoError(err) {
+		return
+	}
+	a.ElementsMatch([]string{"templates/app.tmpl", "values/secrets/app.yaml"}, changed)
+	a.ElementsMatch(changeset.ChangeSet{
+		{Kind: changeset.Added, Path: "templates/app.tmpl"},
+		{Kind: changeset.Added, Path: "values/secrets/app.yaml"},
+	}, source.(ChangeSetSource).Changes())
+
+	changed, err = source.Refresh(ctx)
+	if a.NoError(err) {
+		a.Empty(changed)
+	}
+}
+
+func TestMountEnvVars(t *testing.T) {
+	assert.Equal(
+		t,
+		envvars.EnvVars{"KONVAHTI_VALUES_SECRETS_GIT_COMMIT=abc", "VALUES_SECRETS_OTHER=value"},
+		mountEnvVars("values/secrets", envvars.EnvVars{"KONVAHTI_GIT_COMMIT=abc", "OTHER=value"}),
+	)
+}
+
+func TestCompositeValidate(t *testing.T) {
+	a := assert.New(t)
+	for _, paths := range [][]string{
+		{"templates", "templates"},
+		{"templates", "templates/sub"},
+		{"../templates"},
+		{"/templates"},
+		{""},
+	} {
+		config := Config{Directory: "combined"}
+		for i, p := range paths {
+			config.Sources = append(config.Sources, MountConfig{Path: p})
+			config.Sources[i].Local = &local.Config{Directory: "sources"}
+		}
+		a.Error(config.validateSources(), "paths: %v", paths)
+	}
+}
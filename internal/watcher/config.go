@@ -4,21 +4,57 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/kelseyhightower/envconfig"
 	"gitlab.com/lepovirta/konvahti/internal/action"
+	"gitlab.com/lepovirta/konvahti/internal/archive"
+	"gitlab.com/lepovirta/konvahti/internal/azureblob"
+	"gitlab.com/lepovirta/konvahti/internal/consul"
+	"gitlab.com/lepovirta/konvahti/internal/etcd"
 	"gitlab.com/lepovirta/konvahti/internal/file"
+	"gitlab.com/lepovirta/konvahti/internal/gcs"
 	"gitlab.com/lepovirta/konvahti/internal/git"
+	"gitlab.com/lepovirta/konvahti/internal/http"
+	"gitlab.com/lepovirta/konvahti/internal/kubernetes"
+	"gitlab.com/lepovirta/konvahti/internal/local"
+	"gitlab.com/lepovirta/konvahti/internal/oci"
 	"gitlab.com/lepovirta/konvahti/internal/s3"
+	"gitlab.com/lepovirta/konvahti/internal/sftp"
 	"gopkg.in/yaml.v3"
 )
 
+// SourceConfig specifies a single remote source. Exactly one of the sources must be set.
+type SourceConfig struct {
+	Git        *git.Config        `yaml:"git,omitempty"`
+	GitBundle  *git.BundleConfig  `yaml:"gitBundle,omitempty"`
+	S3         *s3.Config         `yaml:"s3,omitempty"`
+	HTTP       *http.Config       `yaml:"http,omitempty"`
+	Archive    *archive.Config    `yaml:"archive,omitempty"`
+	Local      *local.Config      `yaml:"local,omitempty"`
+	SFTP       *sftp.Config       `yaml:"sftp,omitempty"`
+	GCS        *gcs.Config        `yaml:"gcs,omitempty"`
+	AzureBlob  *azureblob.Config  `yaml:"azureBlob,omitempty"`
+	Consul     *consul.Config     `yaml:"consul,omitempty"`
+	Etcd       *etcd.Config       `yaml:"etcd,omitempty"`
+	OCI        *oci.Config        `yaml:"oci,omitempty"`
+	Kubernetes *kubernetes.Config `yaml:"kubernetes,omitempty"`
+}
+
+// MountConfig specifies a remote source that is mounted to a sub-directory of the watcher directory.
+type MountConfig struct {
+	Path         string `yaml:"path"`
+	SourceConfig `yaml:",inline"`
+}
+
 type Config struct {
-	Name           string          `yaml:"name"`
-	Git            *git.Config     `yaml:"git,omitempty"`
-	S3             *s3.Config      `yaml:"s3,omitempty"`
+	Name           string `yaml:"name"`
+	SourceConfig   `yaml:",inline"`
+	Sources        []MountConfig   `yaml:"sources,omitempty"`
+	Directory      string          `yaml:"directory,omitempty"`
 	RefreshTimeout time.Duration   `yaml:"refreshTimeout,omitempty"`
 	Interval       time.Duration   `yaml:"interval,omitempty"`
 	Actions        []action.Config `yaml:"actions,omitempty"`
@@ -39,10 +75,79 @@ func (c *Config) FromYAMLFile(fs billy.Filesystem, filename string) error {
 }
 
 func (c *Config) Validate() error {
-	if c.Git == nil && c.S3 == nil {
+	if len(c.Sources) > 0 {
+		if c.SourceConfig.count() > 0 {
+			return fmt.Errorf("both sources and a single remote source specified")
+		}
+		if err := c.validateSources(); err != nil {
+			return err
+		}
+	} else if err := c.SourceConfig.Validate(); err != nil {
+		return err
+	}
+
+	if len(c.Actions) == 0 {
+		return fmt.Errorf("no actions specified")
+	}
+
+	for i, action := range c.Actions {
+		if err := action.Validate(); err != nil {
+			return fmt.Errorf("invalid action %d - %s: %w", i, action.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateSources() error {
+	if c.Directory == "" {
+		return fmt.Errorf("no directory specified for the sources")
+	}
+	for i, source := range c.Sources {
+		if !isValidMountPath(source.Path) {
+			return fmt.Errorf("invalid path for source %d: %s", i, source.Path)
+		}
+		for _, other := range c.Sources[:i] {
+			if mountPathsOverlap(other.Path, source.Path) {
+				return fmt.Errorf("source paths %s and %s overlap", other.Path, source.Path)
+			}
+		}
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("invalid source %s: %w", source.Path, err)
+		}
+	}
+	return nil
+}
+
+// isValidMountPath checks that the path points to a sub-directory of the watcher directory.
+func isValidMountPath(p string) bool {
+	return p != "" &&
+		!path.IsAbs(p) &&
+		path.Clean(p) == p &&
+		p != "." &&
+		p != ".." &&
+		!strings.HasPrefix(p, "../")
+}
+
+func mountPathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+func (c *SourceConfig) count() (sourceCount int) {
+	for _, isSet := range []bool{c.Git != nil, c.GitBundle != nil, c.S3 != nil, c.HTTP != nil, c.Archive != nil, c.Local != nil, c.SFTP != nil, c.GCS != nil, c.AzureBlob != nil, c.Consul != nil, c.Etcd != nil, c.OCI != nil, c.Kubernetes != nil} {
+		if isSet {
+			sourceCount++
+		}
+	}
+	return
+}
+
+func (c *SourceConfig) Validate() error {
+	sourceCount := c.count()
+	if sourceCount == 0 {
 		return fmt.Errorf("no remote source specified")
 	}
-	if c.Git != nil && c.S3 != nil {
+	if sourceCount > 1 {
 		return fmt.Errorf("too many remote sources specified")
 	}
 
@@ -51,22 +156,66 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("invalid git remote source: %w", err)
 		}
 	}
+	if c.GitBundle != nil {
+		if err := c.GitBundle.Validate(); err != nil {
+			return fmt.Errorf("invalid git bundle source: %w", err)
+		}
+	}
 	if c.S3 != nil {
 		if err := c.S3.Validate(); err != nil {
 			return fmt.Errorf("invalid s3 remote source: %w", err)
 		}
 	}
-
-	if len(c.Actions) == 0 {
-		return fmt.Errorf("no actions specified")
+	if c.HTTP != nil {
+		if err := c.HTTP.Validate(); err != nil {
+			return fmt.Errorf("invalid http remote source: %w", err)
+		}
 	}
-
-	for i, action := range c.Actions {
-		if err := action.Validate(); err != nil {
-			return fmt.Errorf("invalid action %d - %s: %w", i, action.Name, err)
+	if c.Archive != nil {
+		if err := c.Archive.Validate(); err != nil {
+			return fmt.Errorf("invalid archive remote source: %w", err)
+		}
+	}
+	if c.Local != nil {
+		if err := c.Local.Validate(); err != nil {
+			return fmt.Errorf("invalid local source: %w", err)
+		}
+	}
+	if c.SFTP != nil {
+		if err := c.SFTP.Validate(); err != nil {
+			return fmt.Errorf("invalid sftp remote source: %w", err)
+		}
+	}
+	if c.GCS != nil {
+		if err := c.GCS.Validate(); err != nil {
+			return fmt.Errorf("invalid gcs remote source: %w", err)
+		}
+	}
+	if c.AzureBlob != nil {
+		if err := c.AzureBlob.Validate(); err != nil {
+			return fmt.Errorf("invalid azure blob remote source: %w", err)
+		}
+	}
+	if c.Consul != nil {
+		if err := c.Consul.Validate(); err != nil {
+			return fmt.Errorf("invalid consul remote source: %w", err)
+		}
+	}
+	if c.Etcd != nil {
+		if err := c.Etcd.Validate(); err != nil {
+			return fmt.Errorf("invalid etcd remote source: %w", err)
+		}
+	}
+	if c.OCI != nil {
+		if err := c.OCI.Validate(); err != nil {
+			return fmt.Errorf("invalid oci remote source: %w", err)
+		}
+	}
+	if c.Kubernetes != nil {
+		if err := c.Kubernetes.Validate(); err != nil {
+			return fmt.Errorf("invalid kubernetes remote source: %w", err)
 		}
 	}
-
 	return nil
 }
 
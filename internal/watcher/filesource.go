@@ -4,9 +4,21 @@ import (
 	"context"
 	"fmt"
 
+	"gitlab.com/lepovirta/konvahti/internal/archive"
+	"gitlab.com/lepovirta/konvahti/internal/azureblob"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
+	"gitlab.com/lepovirta/konvahti/internal/consul"
 	"gitlab.com/lepovirta/konvahti/internal/env"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
+	"gitlab.com/lepovirta/konvahti/internal/etcd"
+	"gitlab.com/lepovirta/konvahti/internal/gcs"
 	"gitlab.com/lepovirta/konvahti/internal/git"
+	"gitlab.com/lepovirta/konvahti/internal/http"
+	"gitlab.com/lepovirta/konvahti/internal/kubernetes"
+	"gitlab.com/lepovirta/konvahti/internal/local"
+	"gitlab.com/lepovirta/konvahti/internal/oci"
 	"gitlab.com/lepovirta/konvahti/internal/s3"
+	"gitlab.com/lepovirta/konvahti/internal/sftp"
 )
 
 type FileSource interface {
@@ -14,7 +26,59 @@ type FileSource interface {
 	GetDirectory() string
 }
 
+// Notifier is implemented by file sources that can tell when changes are available
+// without waiting for the next interval. The returned channel may be nil when
+// notifications are not available.
+type Notifier interface {
+	Notifications(ctx context.Context) <-chan struct{}
+}
+
+// EnvVarSource is implemented by file sources that expose details about
+// the latest refresh (e.g. versions) to the actions as environment variables.
+type EnvVarSource interface {
+	EnvVars() envvars.EnvVars
+}
+
+// ChangeSetSource is implemented by file sources that can tell how the files
+// were changed (e.g. added or deleted) on the latest refresh.
+// The changes from the other file sources are treated as modified files.
+type ChangeSetSource interface {
+	Changes() changeset.ChangeSet
+}
+
+// StepSource is implemented by file sources that deliver the changes one step at a time
+// (e.g. one commit at a time). The watcher acknowledges each step once its actions have succeeded,
+// and refreshes the source again while there are steps left. The changes from a step that
+// hasn't been acknowledged are delivered again on the next refresh.
+type StepSource interface {
+	Acknowledge() error
+	HasMoreSteps() bool
+}
+
+// sourceChanges describes the changed files using the kinds of the changes when the file source knows them.
+func sourceChanges(source FileSource, changedFiles []string) changeset.ChangeSet {
+	if len(changedFiles) == 0 {
+		// The changes from an earlier refresh might still be reported by the source
+		return nil
+	}
+	if changeSetSource, ok := source.(ChangeSetSource); ok {
+		return changeSetSource.Changes()
+	}
+	return changeset.FromPaths(changeset.Modified, changedFiles)
+}
+
 func fileSourceFromConfig(env *env.Env, config *Config) (FileSource, error) {
+	if len(config.Sources) > 0 {
+		var s compositeSource
+		if err := s.Setup(env, config.Directory, config.Sources); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	return singleFileSourceFromConfig(env, &config.SourceConfig, config.Name)
+}
+
+func singleFileSourceFromConfig(env *env.Env, config *SourceConfig, name string) (FileSource, error) {
 	if config.Git != nil {
 		var s git.GitSource
 		if err := s.Setup(*config.Git); err != nil {
@@ -22,6 +86,13 @@ func fileSourceFromConfig(env *env.Env, config *Config) (FileSource, error) {
 		}
 		return &s, nil
 	}
+	if config.GitBundle != nil {
+		var s git.BundleSource
+		if err := s.Setup(*config.GitBundle); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
 	if config.S3 != nil {
 		var s s3.S3Source
 		if err := s.Setup(env.Fs, *config.S3); err != nil {
@@ -29,5 +100,75 @@ func fileSourceFromConfig(env *env.Env, config *Config) (FileSource, error) {
 		}
 		return &s, nil
 	}
-	return nil, fmt.Errorf("no remote source specified for config %s", config.Name)
+	if config.HTTP != nil {
+		var s http.HTTPSource
+		if err := s.Setup(env.Fs, *config.HTTP); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.Archive != nil {
+		var s archive.ArchiveSource
+		if err := s.Setup(env.Fs, *config.Archive); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.Local != nil {
+		var s local.LocalSource
+		if err := s.Setup(env.Fs, *config.Local); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.SFTP != nil {
+		var s sftp.SFTPSource
+		if err := s.Setup(env.Fs, *config.SFTP); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.GCS != nil {
+		var s gcs.GCSSource
+		if err := s.Setup(env.Fs, *config.GCS); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.AzureBlob != nil {
+		var s azureblob.AzureBlobSource
+		if err := s.Setup(env.Fs, *config.AzureBlob); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.Consul != nil {
+		var s consul.ConsulSource
+		if err := s.Setup(env.Fs, *config.Consul); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.Etcd != nil {
+		var s etcd.EtcdSource
+		if err := s.Setup(env.Fs, *config.Etcd); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.OCI != nil {
+		var s oci.OCISource
+		if err := s.Setup(env.Fs, *config.OCI); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	if config.Kubernetes != nil {
+		var s kubernetes.KubernetesSource
+		if err := s.Setup(env.Fs, *config.Kubernetes); err != nil {
+			return nil, err
+		}
+		return &s, nil
+	}
+	return nil, fmt.Errorf("no remote source specified for config %s", name)
 }
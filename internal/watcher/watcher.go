@@ -3,11 +3,14 @@ package watcher
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/rs/zerolog"
 	"gitlab.com/lepovirta/konvahti/internal/action"
+	"gitlab.com/lepovirta/konvahti/internal/changeset"
 	"gitlab.com/lepovirta/konvahti/internal/env"
+	"gitlab.com/lepovirta/konvahti/internal/envvars"
 	"gitlab.com/lepovirta/konvahti/internal/retry"
 )
 
@@ -63,28 +66,73 @@ func (s *Watcher) Logger() zerolog.Logger {
 }
 
 func (s *Watcher) Run(ctx context.Context) error {
+	defer s.closeFileSource()
+
 	if s.config.ShouldRunOnce() {
 		s.logger.Debug().Msg("running only once")
 		return s.runOnce(ctx)
 	}
 
 	s.logger.Debug().Msg("running in a continuous loop")
+	var notifications <-chan struct{}
+	if notifier, ok := s.fileSource.(Notifier); ok {
+		notifications = notifier.Notifications(s.logger.WithContext(ctx))
+	}
+
 	for {
+		// Errors are not propagated here so that we can
+		// try again after the interval has elapsed.
+		if err := s.runOnce(ctx); err != nil {
+			s.logger.Error().Err(err).Msg("watcher failed")
+		}
+
+		timer := time.NewTimer(s.config.Interval)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return nil
-		default:
-			// Errors are not propagated here so that we can
-			// try again after the interval has elapsed.
-			if err := s.runOnce(ctx); err != nil {
-				s.logger.Error().Err(err).Msg("watcher failed")
-			}
-			time.Sleep(s.config.Interval)
+		case <-notifications:
+			timer.Stop()
+			s.logger.Debug().Msg("changes notified by the file source")
+		case <-timer.C:
+		}
+	}
+}
+
+// closeFileSource releases the resources held by the file source (e.g. the SSH agent connection).
+func (s *Watcher) closeFileSource() {
+	if closer, ok := s.fileSource.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Warn().Err(err).Msg("failed to close the file source")
 		}
 	}
 }
 
 func (s *Watcher) runOnce(ctx context.Context) error {
+	stepSource, ok := s.fileSource.(StepSource)
+	if !ok {
+		return s.runStep(ctx)
+	}
+
+	// Processing stops at the first failing step, so that it's retried on the next run
+	for {
+		if err := s.runStep(ctx); err != nil {
+			return err
+		}
+		if err := stepSource.Acknowledge(); err != nil {
+			return err
+		}
+		if !stepSource.HasMoreSteps() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.logger.Debug().Msg("more steps found from the file source")
+	}
+}
+
+func (s *Watcher) runStep(ctx context.Context) error {
 	logger := s.logger.With().Int64("runId", time.Now().Unix()).Logger()
 	ctx = s.logger.WithContext(ctx)
 
@@ -97,15 +145,20 @@ func (s *Watcher) runOnce(ctx context.Context) error {
 	}
 	logger.Debug().Msgf("%d file changes found", len(changedFiles))
 
-	matches := s.findActionsToRun(changedFiles, logger)
+	matches := s.findActionsToRun(sourceChanges(s.fileSource, changedFiles), logger)
 	if len(matches) == 0 {
 		logger.Debug().Msg("no matches found -> no actions to run")
 		return nil
 	}
 
+	var sourceEnvVars envvars.EnvVars
+	if envVarSource, ok := s.fileSource.(EnvVarSource); ok {
+		sourceEnvVars = envVarSource.EnvVars()
+	}
+
 	for _, i := range matches {
 		runner := s.runners[i]
-		if err := runner.Run(ctx, logger); err != nil {
+		if err := runner.Run(ctx, logger, sourceEnvVars); err != nil {
 			return fmt.Errorf("runner %s failed: %w", runner.Name(), err)
 		}
 	}
@@ -114,12 +167,12 @@ func (s *Watcher) runOnce(ctx context.Context) error {
 }
 
 func (s *Watcher) findActionsToRun(
-	changedFiles []string,
+	changes changeset.ChangeSet,
 	logger zerolog.Logger,
 ) (actionsToRun []int) {
 	actionsToRun = make([]int, 0, len(s.runners))
 	for i, runner := range s.runners {
-		if filename := runner.MatchAny(changedFiles); filename != "" {
+		if filename := runner.MatchChanges(changes); filename != "" {
 			actionsToRun = append(actionsToRun, i)
 		}
 	}